@@ -1,6 +1,8 @@
 package database
 
 import (
+	"log"
+
 	"swirl-backend/internal/models"
 
 	"gorm.io/driver/postgres"
@@ -16,12 +18,292 @@ func Connect(databaseURL string) (*gorm.DB, error) {
 	return db, nil
 }
 
+// BootstrapRoles назначает роли пользователям из конфигурации (без учета регистра имени).
+// Если пользователь указан в обоих списках, он становится админом.
+// Роли, выданные через админку, для остальных пользователей не меняются
+func BootstrapRoles(db *gorm.DB, admins, moderators []string) error {
+	assignments := []struct {
+		role      models.Role
+		usernames []string
+	}{
+		{models.RoleModerator, moderators},
+		{models.RoleAdmin, admins},
+	}
+
+	for _, a := range assignments {
+		for _, username := range a.usernames {
+			result := db.Model(&models.User{}).
+				Where("LOWER(username) = LOWER(?)", username).
+				Update("role", a.role)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				log.Printf("Role bootstrap: user %s not found", username)
+			}
+		}
+	}
+	return nil
+}
+
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	// Флаг is_roulette появляется этой миграцией: старые чаты чатрулетки размечаются
+	// по имени один раз, иначе обычный чат с таким именем стал бы доступен гостям
+	backfillRoulette := db.Migrator().HasTable(&models.Chat{}) && !db.Migrator().HasColumn(&models.Chat{}, "is_roulette")
+
+	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Chat{},
 		&models.ChatUser{},
 		&models.Message{},
 		&models.SearchQueue{},
-	)
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.ChatInvite{},
+		&models.ChatJoinRequest{},
+		&models.MessageReaction{},
+		&models.MessageRevision{},
+		&models.MessageMention{},
+		&models.HiddenMessage{},
+		&models.UserBlock{},
+		&models.ScheduledMessage{},
+		&models.IdempotencyKey{},
+		&models.Upload{},
+		&models.Sticker{},
+		&models.LinkPreviewCache{},
+		&models.Poll{},
+		&models.PollOption{},
+		&models.PollVote{},
+	); err != nil {
+		return err
+	}
+
+	if backfillRoulette {
+		if err := db.Exec(`UPDATE chats SET is_roulette = true
+			WHERE type = 'saved' OR name = 'Chatroulette Chat'`).Error; err != nil {
+			return err
+		}
+	}
+	if err := migrateReadCursors(db); err != nil {
+		return err
+	}
+	if err := migrateLikes(db); err != nil {
+		return err
+	}
+	if err := migrateUniqueMembers(db); err != nil {
+		return err
+	}
+	if err := migrateUploads(db); err != nil {
+		return err
+	}
+	if err := migrateUsernames(db); err != nil {
+		return err
+	}
+
+	// То, что AutoMigrate сделать не умеет: функциональные индексы и перенос данных
+	statements := []string{
+		// Email уникален без учета регистра
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email))",
+		// Создатель чата становится владельцем, если владельца еще нет
+		`UPDATE chat_users cu SET role = 'owner' FROM chats c
+			WHERE cu.chat_id = c.id AND cu.user_id = c.created_by AND cu.role <> 'owner'
+			AND NOT EXISTS (SELECT 1 FROM chat_users o WHERE o.chat_id = cu.chat_id AND o.role = 'owner')`,
+		// Не больше одной ожидающей заявки на вступление от пользователя в чат
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_join_requests_pending
+			ON chat_join_requests (chat_id, user_id) WHERE status = 'pending'`,
+		// Существующие личные чаты из двух участников получают пару (самый старый на каждую пару)
+		`UPDATE chats c SET direct_user_low = p.low, direct_user_high = p.high
+			FROM (
+				SELECT DISTINCT ON (LEAST(a.user_id, b.user_id), GREATEST(a.user_id, b.user_id))
+					ch.id, LEAST(a.user_id, b.user_id) AS low, GREATEST(a.user_id, b.user_id) AS high
+				FROM chats ch
+				JOIN chat_users a ON a.chat_id = ch.id
+				JOIN chat_users b ON b.chat_id = ch.id AND a.user_id < b.user_id
+				WHERE ch.type = 'private' AND ch.is_roulette = false
+					AND (SELECT COUNT(*) FROM chat_users x WHERE x.chat_id = ch.id) = 2
+				ORDER BY LEAST(a.user_id, b.user_id), GREATEST(a.user_id, b.user_id), ch.created_at
+			) p
+			WHERE c.id = p.id AND c.direct_user_low IS NULL
+				AND NOT EXISTS (SELECT 1 FROM chats d WHERE d.direct_user_low = p.low AND d.direct_user_high = p.high)`,
+		// Последние сообщения чата (список чатов, лента сообщений)
+		"CREATE INDEX IF NOT EXISTS idx_messages_chat_created ON messages (chat_id, created_at DESC, id DESC)",
+		// Полнотекстовый поиск: колонка пересчитывается самим Postgres при вставке и редактировании.
+		// Конфигурация russian стеммит и русские, и английские слова
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (CASE WHEN type <> 'system' THEN to_tsvector('russian', content) END) STORED`,
+		"CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector)",
+		// Повторная отправка с тем же client_message_id не создает второе сообщение
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_id ON messages (user_id, client_message_id)
+			WHERE client_message_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_scheduled_messages_client_id ON scheduled_messages (user_id, client_message_id)
+			WHERE client_message_id IS NOT NULL`,
+		// Исчезающие сообщения, которые еще не стерты
+		"CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages (expires_at) WHERE expires_at IS NOT NULL AND deleted_at IS NULL",
+		// Очередь отложенных сообщений: воркер выбирает ожидающие по времени отправки
+		"CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (send_at) WHERE status IN ('pending', 'sending')",
+		// Непрочитанные упоминания пользователя для счетчика в списке чатов
+		"CREATE INDEX IF NOT EXISTS idx_message_mentions_unread ON message_mentions (user_id, message_id) WHERE read_at IS NULL",
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateReadCursors переносит прочтения из массивов messages.read_by в курсоры chat_users:
+// курсор участника встает на последнее прочитанное или отправленное им сообщение.
+// После переноса старые колонки удаляются, поэтому повторно перенос не выполняется
+func migrateReadCursors(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("messages", "read_by") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			UPDATE chat_users cu SET last_read_message_id = r.id, last_read_at = r.created_at
+			FROM (
+				SELECT DISTINCT ON (s.chat_id, s.user_id) s.chat_id, s.user_id, s.id, s.created_at
+				FROM (
+					SELECT m.chat_id, r.user_id, m.id, m.created_at
+					FROM messages m CROSS JOIN LATERAL unnest(m.read_by) AS r(user_id)
+					UNION ALL
+					SELECT m.chat_id, m.user_id, m.id, m.created_at FROM messages m
+				) s
+				ORDER BY s.chat_id, s.user_id, s.created_at DESC, s.id DESC
+			) r
+			WHERE cu.chat_id = r.chat_id AND cu.user_id = r.user_id
+				AND (cu.last_read_at IS NULL OR (cu.last_read_at, cu.last_read_message_id) < (r.created_at, r.id))`).Error
+		if err != nil {
+			return err
+		}
+		// Прочтение теперь вычисляется по курсорам
+		if err := tx.Exec(`UPDATE messages SET status = 'delivered' WHERE status = 'read'`).Error; err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE messages DROP COLUMN read_by, DROP COLUMN IF EXISTS read_at").Error
+	})
+}
+
+// migrateLikes переносит лайки из messages.liked_by в реакции ❤️ и удаляет старые колонки
+func migrateLikes(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("messages", "liked_by") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO message_reactions (id, message_id, user_id, emoji, created_at)
+			SELECT gen_random_uuid(), m.id, l.user_id, ?, COALESCE(m.liked_at, m.created_at)
+			FROM messages m CROSS JOIN LATERAL unnest(m.liked_by) AS l(user_id)
+			WHERE EXISTS (SELECT 1 FROM users u WHERE u.id = l.user_id)
+			ON CONFLICT DO NOTHING`, models.ReactionHeart).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE messages DROP COLUMN liked_by, DROP COLUMN IF EXISTS likes_count, DROP COLUMN IF EXISTS liked_at").Error
+	})
+}
+
+// migrateUniqueMembers убирает повторные записи участника в чате (остается старшая роль,
+// при равных - более ранняя) и создает уникальный индекс, который не даст им появиться снова
+func migrateUniqueMembers(db *gorm.DB) error {
+	if db.Migrator().HasIndex("chat_users", "idx_chat_users_chat_user") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			DELETE FROM chat_users WHERE id IN (
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (
+						PARTITION BY chat_id, user_id
+						ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, joined_at, id
+					) AS n
+					FROM chat_users
+				) d WHERE d.n > 1
+			)`).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("CREATE UNIQUE INDEX idx_chat_users_chat_user ON chat_users (chat_id, user_id)").Error
+	})
+}
+
+// migrateUsernames делает имя пользователя уникальным без учета регистра. Имена, которые до этого
+// совпадали с точностью до регистра, оставляет самому раннему пользователю, а остальным добавляет
+// суффикс из начала их id (Alice -> Alice_3f2a9c). Переименования пишутся в лог
+func migrateUsernames(db *gorm.DB) error {
+	if db.Migrator().HasIndex("users", "idx_users_username_lower") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var renamed []struct {
+			ID       string
+			Username string
+		}
+		err := tx.Raw(`
+			UPDATE users u SET username = u.username || '_' || left(u.id::text, 6)
+			FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(username) ORDER BY created_at, id) AS n
+				FROM users
+			) d
+			WHERE u.id = d.id AND d.n > 1
+			RETURNING u.id, u.username`).Scan(&renamed).Error
+		if err != nil {
+			return err
+		}
+		for _, u := range renamed {
+			log.Printf("Username migration: user %s renamed to %s", u.ID, u.Username)
+		}
+		return tx.Exec("CREATE UNIQUE INDEX idx_users_username_lower ON users (LOWER(username))").Error
+	})
+}
+
+// migrateUploads заводит записи о загрузках для файлов, прикрепленных к сообщениям до появления uploads:
+// без записи такой файл нельзя переслать, отредактировать сообщение с ним или удалить вместе с исчезнувшим.
+// Владелец - автор самого раннего сообщения с файлом, тип определяется по расширению, а если оно
+// незнакомо - по типу сообщения. Размер неизвестен и остается 0. Файлы, у которых запись уже есть, пропускаются
+func migrateUploads(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO uploads (id, user_id, file_name, url, content_type, media_type, size, created_at)
+		SELECT gen_random_uuid(), f.user_id, split_part(f.media_url, '/', 4), f.media_url, f.content_type,
+			CASE
+				WHEN f.content_type LIKE 'image/%' THEN 'image'
+				WHEN f.content_type LIKE 'video/%' THEN 'video'
+				WHEN f.content_type LIKE 'audio/%' THEN 'voice'
+				WHEN f.type IN ('image', 'gif') THEN 'image'
+				WHEN f.type = 'video' THEN 'video'
+				WHEN f.type = 'voice' THEN 'voice'
+				ELSE 'unknown'
+			END,
+			0, f.created_at
+		FROM (
+			SELECT DISTINCT ON (m.media_url) m.media_url, m.user_id, m.type, m.created_at,
+				CASE lower(substring(m.media_url FROM '\.([^./]+)$'))
+					WHEN 'jpg' THEN 'image/jpeg'
+					WHEN 'jpeg' THEN 'image/jpeg'
+					WHEN 'png' THEN 'image/png'
+					WHEN 'gif' THEN 'image/gif'
+					WHEN 'webp' THEN 'image/webp'
+					WHEN 'mp4' THEN 'video/mp4'
+					WHEN 'webm' THEN 'video/webm'
+					WHEN 'mov' THEN 'video/quicktime'
+					WHEN 'mp3' THEN 'audio/mpeg'
+					WHEN 'wav' THEN 'audio/wav'
+					WHEN 'ogg' THEN 'audio/ogg'
+					WHEN 'm4a' THEN 'audio/mp4'
+					ELSE 'application/octet-stream'
+				END AS content_type
+			FROM messages m
+			WHERE m.media_url ~ '^/uploads/[^/]+/[^/]+$'
+				AND EXISTS (SELECT 1 FROM users u WHERE u.id = m.user_id)
+			ORDER BY m.media_url, m.created_at, m.id
+		) f
+		ON CONFLICT (url) DO NOTHING`).Error
 }
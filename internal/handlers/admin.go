@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	db  *gorm.DB
+	hub *websocket.Hub
+}
+
+func NewAdminHandler(db *gorm.DB, hub *websocket.Hub) *AdminHandler {
+	return &AdminHandler{db: db, hub: hub}
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// GetUsers возвращает список пользователей с поиском по имени
+func (h *AdminHandler) GetUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	query := h.db.Model(&models.User{})
+	if q := c.Query("q"); q != "" {
+		query = query.Where("username ILIKE ?", "%"+q+"%")
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var users []models.User
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	views := make([]models.AdminUserView, len(users))
+	for i := range users {
+		views[i] = users[i].AdminView()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": views,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetUser возвращает полную информацию о пользователе
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	var user models.User
+	if err := h.db.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":        user.AdminView(),
+		"permissions": user.Role.Permissions(),
+	})
+}
+
+// UpdateUserRole назначает пользователю роль
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	targetID := c.Param("id")
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.Role(req.Role)
+	if !role.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	// Свою роль менять нельзя, чтобы случайно не остаться без администратора
+	if targetID == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", targetID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.IsGuest && role != models.RoleUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Guest accounts cannot have elevated roles"})
+		return
+	}
+
+	user.Role = role
+	if err := h.db.Model(&user).Update("role", role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.AdminView())
+}
+
+// BlockUser блокирует пользователя, завершает все его сессии и закрывает открытые WebSocket-соединения
+func (h *AdminHandler) BlockUser(c *gin.Context) {
+	user, ok := h.moderatableUser(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("blocked_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.SearchQueue{}).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.ID, "")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+	h.hub.DisconnectUser(user.ID.String())
+
+	user.BlockedAt = &now
+	c.JSON(http.StatusOK, user.AdminView())
+}
+
+// UnblockUser снимает блокировку с пользователя
+func (h *AdminHandler) UnblockUser(c *gin.Context) {
+	user, ok := h.moderatableUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Model(user).Update("blocked_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+
+	user.BlockedAt = nil
+	c.JSON(http.StatusOK, user.AdminView())
+}
+
+// DeleteMessage удаляет любое сообщение (модерация)
+func (h *AdminHandler) DeleteMessage(c *gin.Context) {
+	messageID := c.Param("id")
+
+	var message models.Message
+	if err := h.db.Where("id = ?", messageID).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	if message.IsDeleted() {
+		c.JSON(http.StatusOK, message)
+		return
+	}
+
+	// Сообщение остается "надгробием", чтобы не ломать ответы на него
+	moderatorID := uuid.MustParse(c.GetString("user_id"))
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return tombstoneMessage(tx, &message, moderatorID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+
+	broadcastMessageDeleted(h.hub, &message)
+	c.JSON(http.StatusOK, message)
+}
+
+// DeleteChat удаляет любой чат вместе с сообщениями (модерация)
+func (h *AdminHandler) DeleteChat(c *gin.Context) {
+	chatID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	var chat models.Chat
+	if err := h.db.First(&chat, "id = ?", chatID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return deleteChatCascade(tx, chat.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat"})
+		return
+	}
+
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "chat_deleted",
+		ChatID:  chat.ID.String(),
+		Payload: gin.H{"chat_id": chat.ID},
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
+}
+
+// moderatableUser загружает пользователя из :id и проверяет, что текущий
+// пользователь вправе его модерировать (нельзя себя и равных или старших по роли)
+func (h *AdminHandler) moderatableUser(c *gin.Context) (*models.User, bool) {
+	targetID := c.Param("id")
+	if targetID == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot moderate yourself"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", targetID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	if !models.Role(c.GetString("role")).Outranks(user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+		return nil, false
+	}
+
+	return &user, true
+}
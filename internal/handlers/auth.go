@@ -1,37 +1,65 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"swirl-backend/internal/config"
+	"swirl-backend/internal/mailer"
 	"swirl-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// sessionTTL время жизни сессии (и JWT токена)
+const sessionTTL = 7 * 24 * time.Hour
+
 type AuthHandler struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, m mailer.Mailer) *AuthHandler {
+	return &AuthHandler{db: db, cfg: cfg, mailer: m}
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=20"`
 	Password string `json:"password" binding:"required,min=6"`
+	Email    string `json:"email" binding:"omitempty,email"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+	Login    string `json:"login"`    // Имя пользователя или email
+	Username string `json:"username"` // Старое поле, оставлено для совместимости
 	Password string `json:"password" binding:"required"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type ForgotPasswordRequest struct {
+	Login string `json:"login" binding:"required"` // Имя пользователя или email
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=6"`
+}
+
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token string             `json:"token"`
+	User  models.AccountView `json:"user"`
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -41,13 +69,20 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, существует ли пользователь
-	var existingUser models.User
-	if err := h.db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
+	// Проверяем, существует ли пользователь (без учета регистра)
+	if h.usernameTaken(req.Username, uuid.Nil) {
 		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
 
+	// Адрес почты сохраняется в профиле только после подтверждения,
+	// но занятый чужой подтвержденный адрес сразу отклоняем
+	email := normalizeEmail(req.Email)
+	if email != "" && h.emailTaken(email, uuid.Nil) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
 	// Создаем нового пользователя
 	user := models.User{
 		Username: req.Username,
@@ -63,8 +98,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if email != "" {
+		if err := h.sendEmailVerification(&user, email); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
 	// Генерируем JWT токен
-	token, err := h.generateToken(user.ID.String())
+	token, err := h.generateToken(user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -72,7 +113,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, AuthResponse{
 		Token: token,
-		User:  user,
+		User:  user.Account(),
 	})
 }
 
@@ -83,21 +124,35 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Находим пользователя
-	var user models.User
-	if err := h.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
+	login := req.Login
+	if login == "" {
+		login = req.Username
+	}
+	if login == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login is required"})
+		return
+	}
+
+	// Находим пользователя по имени или email
+	user, err := h.findUserByLogin(login)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Проверяем пароль
-	if err := user.CheckPassword(req.Password); err != nil {
+	if user.Passwordless || user.CheckPassword(req.Password) != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	if user.IsBlocked() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is blocked"})
+		return
+	}
+
 	// Генерируем JWT токен
-	token, err := h.generateToken(user.ID.String())
+	token, err := h.generateToken(user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -105,7 +160,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	c.JSON(http.StatusOK, AuthResponse{
 		Token: token,
-		User:  user,
+		User:  user.Account(),
 	})
 }
 
@@ -118,7 +173,7 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, user.Account())
 }
 
 func (h *AuthHandler) UpdateProfile(c *gin.Context) {
@@ -145,7 +200,11 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 	}
 
 	// Обновляем поля профиля
-	if updateData.Username != "" {
+	if updateData.Username != "" && updateData.Username != user.Username {
+		if h.usernameTaken(updateData.Username, user.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
 		user.Username = updateData.Username
 	}
 	
@@ -174,7 +233,7 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, user.Account())
 }
 
 // UpdateOnlineStatus обновляет статус онлайн пользователя
@@ -226,12 +285,195 @@ func (h *AuthHandler) GetPublicProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, publicProfile)
 }
 
-func (h *AuthHandler) generateToken(userID string) (string, error) {
+// ChangePassword меняет пароль и завершает все остальные сессии пользователя
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.GetString("session_id")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// У пользователей без пароля задать его можно только через сброс по почте
+	if user.Passwordless {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is not set, use password reset"})
+		return
+	}
+
+	// Проверяем текущий пароль
+	if err := user.CheckPassword(req.CurrentPassword); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	if err := user.HashPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", user.Password).Error; err != nil {
+			return err
+		}
+		// Текущая сессия остается, остальные отзываем
+		return revokeSessions(tx, user.ID, sessionID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// ForgotPassword отправляет на почту пользователя ссылку для сброса пароля.
+// Ответ всегда одинаковый, чтобы нельзя было проверить существование аккаунта
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If the account exists, a reset link has been sent"}
+
+	// Письмо отправляем только на подтвержденный адрес
+	user, err := h.findUserByLogin(req.Login)
+	if err != nil || user.Email == nil || !user.EmailVerified {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, hash, err := models.NewSecretToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reset token"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Предыдущие неиспользованные токены больше не действуют
+		now := time.Now()
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: now.Add(h.cfg.PasswordResetTTL),
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	// Письмо уходит в фоне: по времени ответа нельзя понять, зарегистрирован ли адрес
+	link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimRight(h.cfg.AppURL, "/"), token)
+	msg := mailer.Message{
+		To:      *user.Email,
+		Subject: "Swirl: сброс пароля",
+		Body: fmt.Sprintf("Привет, %s!\n\nЧтобы задать новый пароль, перейди по ссылке:\n%s\n\nСсылка действует %s. Если ты не запрашивал сброс, просто проигнорируй это письмо.",
+			user.Username, link, h.cfg.PasswordResetTTL),
+	}
+	go func() {
+		if err := h.mailer.Send(msg); err != nil {
+			log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword задает новый пароль по одноразовому токену и завершает все сессии
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := user.HashPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	newPassword := user.Password
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Атомарно помечаем токен использованным, чтобы его нельзя было применить повторно
+		now := time.Now()
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", models.HashSecretToken(req.Token), now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var resetToken models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", models.HashSecretToken(req.Token)).First(&resetToken).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Updates(map[string]interface{}{
+			"password":     newPassword,
+			"passwordless": false,
+		}).Error; err != nil {
+			return err
+		}
+
+		return revokeSessions(tx, resetToken.UserID, "")
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// revokeSessions отзывает все активные сессии пользователя, кроме exceptSessionID
+func revokeSessions(tx *gorm.DB, userID uuid.UUID, exceptSessionID string) error {
+	query := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptSessionID != "" {
+		query = query.Where("id <> ?", exceptSessionID)
+	}
+	return query.Update("revoked_at", time.Now()).Error
+}
+
+// generateToken создает новую сессию и подписывает JWT токен с ее ID
+func (h *AuthHandler) generateToken(userID uuid.UUID) (string, error) {
+	session := models.Session{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(sessionTTL),
+	}
+	if err := h.db.Create(&session).Error; err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 дней
+		"user_id":    userID.String(),
+		"session_id": session.ID.String(),
+		"exp":        session.ExpiresAt.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte("your-super-secret-jwt-key-change-in-production")) // В продакшене использовать переменную окружения
+	return token.SignedString([]byte(h.cfg.JWTSecret))
 }
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlockUser блокирует пользователя: он больше не может писать в личные чаты с текущим пользователем
+func (h *AuthHandler) BlockUser(c *gin.Context) {
+	userUUID, targetUUID, ok := blockParties(c)
+	if !ok {
+		return
+	}
+
+	var target models.User
+	if err := h.db.Where("id = ?", targetUUID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	block := models.UserBlock{UserID: userUUID, BlockedUserID: targetUUID}
+	if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User blocked successfully"})
+}
+
+// UnblockUser снимает блокировку
+func (h *AuthHandler) UnblockUser(c *gin.Context) {
+	userUUID, targetUUID, ok := blockParties(c)
+	if !ok {
+		return
+	}
+
+	result := h.db.Where("user_id = ? AND blocked_user_id = ?", userUUID, targetUUID).Delete(&models.UserBlock{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not blocked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked successfully"})
+}
+
+// GetBlockedUsers возвращает пользователей, заблокированных текущим
+func (h *AuthHandler) GetBlockedUsers(c *gin.Context) {
+	var blocks []models.UserBlock
+	if err := h.db.Preload("BlockedUser").Where("user_id = ?", c.GetString("user_id")).
+		Order("created_at DESC").Find(&blocks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blocked users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+// blockParties текущий пользователь и пользователь из :id; себя заблокировать нельзя
+func blockParties(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userUUID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	targetUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	if targetUUID == userUUID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot block yourself"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userUUID, targetUUID, true
+}
+
+var errRecipientBlocked = errors.New("recipient is blocked")
+
+// blockedByRecipient проверяет, можно ли отправителю писать в чат: в личных чатах собеседник
+// не должен блокировать отправителя и не должен быть заблокирован администрацией. Группы не проверяются
+func blockedByRecipient(db *gorm.DB, chatID, senderID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.ChatUser{}).
+		Joins("JOIN users ON users.id = chat_users.user_id").
+		Joins("JOIN chats ON chats.id = chat_users.chat_id").
+		Where("chat_users.chat_id = ? AND chat_users.user_id <> ?", chatID, senderID).
+		Where("chats.type <> ?", models.ChatTypeGroup).
+		Where("users.blocked_at IS NOT NULL OR EXISTS (?)",
+			db.Model(&models.UserBlock{}).Select("1").
+				Where("user_blocks.user_id = chat_users.user_id AND user_blocks.blocked_user_id = ?", senderID)).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
+	"swirl-backend/internal/middleware"
 	"swirl-backend/internal/models"
 	"swirl-backend/internal/websocket"
 
@@ -12,15 +14,17 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ChatHandler struct {
-	db  *gorm.DB
-	hub *websocket.Hub
+	db        *gorm.DB
+	hub       *websocket.Hub
+	jwtSecret string
 }
 
-func NewChatHandler(db *gorm.DB, hub *websocket.Hub) *ChatHandler {
-	return &ChatHandler{db: db, hub: hub}
+func NewChatHandler(db *gorm.DB, hub *websocket.Hub, jwtSecret string) *ChatHandler {
+	return &ChatHandler{db: db, hub: hub, jwtSecret: jwtSecret}
 }
 
 type CreateChatRequest struct {
@@ -29,29 +33,174 @@ type CreateChatRequest struct {
 	Type        string `json:"type" binding:"required"`
 }
 
+// chatListRow - строка списка чатов с вычисленными для пользователя полями
+type chatListRow struct {
+	ID                 uuid.UUID
+	LastMessageID      *uuid.UUID
+	LastActivityAt     time.Time
+	UnreadCount        int64
+	UnreadMentionCount int64
+	MutedUntil         *time.Time
+}
+
+// GetChats отдает чаты пользователя по последней активности с keyset-пагинацией (параметр cursor)
 func (h *ChatHandler) GetChats(c *gin.Context) {
 	userID := c.GetString("user_id")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	offset := (page - 1) * limit
+	limit := pageLimit(c, 20, 100)
+
+	var cursorFilter string
+	args := []interface{}{}
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		cursorFilter = "WHERE (COALESCE(lm.created_at, chats.created_at), chats.id) < (@cursor_time, @cursor_id)"
+		args = append(args, sql.Named("cursor_time", cur.Time), sql.Named("cursor_id", cur.ID))
+	}
 
-	var chats []models.Chat
-	query := h.db.Preload("CreatedByUser").
-		Preload("Participants.User").
-		Joins("JOIN chat_users ON chats.id = chat_users.chat_id").
-		Where("chat_users.user_id = ?", userID).
-		Order("chats.updated_at DESC")
+	// Последнее сообщение и счетчики считаются одним запросом:
+	// LATERAL выбирает последнее сообщение каждого чата, страница отбирается по курсору,
+	// и только для нее считаются сообщения после курсора прочтения и непрочитанные упоминания
+	var rows []chatListRow
+	args = append(args,
+		sql.Named("user", userID),
+		sql.Named("system", models.MessageTypeSystem),
+		sql.Named("deleted", models.MessageStatusDeleted),
+		sql.Named("limit", limit+1),
+	)
+	err := h.db.Raw(`
+		WITH page AS (
+			SELECT chats.id,
+				chat_users.last_read_at, chat_users.last_read_message_id, chat_users.muted_until,
+				lm.id AS last_message_id,
+				COALESCE(lm.created_at, chats.created_at) AS last_activity_at
+			FROM chats
+			JOIN chat_users ON chat_users.chat_id = chats.id AND chat_users.user_id = @user
+			LEFT JOIN LATERAL (
+				SELECT m.id, m.created_at FROM messages m
+				WHERE m.chat_id = chats.id AND m.thread_root_id IS NULL
+					AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = @user)
+				ORDER BY m.created_at DESC, m.id DESC
+				LIMIT 1
+			) lm ON true
+			`+cursorFilter+`
+			ORDER BY last_activity_at DESC, chats.id DESC
+			LIMIT @limit
+		)
+		SELECT page.id, page.last_message_id, page.last_activity_at, page.muted_until,
+			COALESCE(unread.total, 0) AS unread_count,
+			COALESCE(mentions.total, 0) AS unread_mention_count
+		FROM page
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS total
+			FROM messages m
+			WHERE m.chat_id = page.id
+				AND m.thread_root_id IS NULL
+				AND m.user_id <> @user
+				AND m.type <> @system
+				AND m.status <> @deleted
+				AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = @user)
+				AND (page.last_read_at IS NULL
+					OR (m.created_at, m.id) > (page.last_read_at, page.last_read_message_id))
+		) unread ON true
+		LEFT JOIN LATERAL (
+			SELECT COUNT(DISTINCT m.id) AS total
+			FROM message_mentions mm
+			JOIN messages m ON m.id = mm.message_id
+			WHERE mm.user_id = @user
+				AND mm.read_at IS NULL
+				AND m.chat_id = page.id
+				AND m.status <> @deleted
+				AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = @user)
+		) mentions ON true
+		ORDER BY page.last_activity_at DESC, page.id DESC`,
+		args...,
+	).Scan(&rows).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chats"})
+		return
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
 
-	if err := query.Offset(offset).Limit(limit).Find(&chats).Error; err != nil {
+	chats, err := h.loadChatList(rows)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chats"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"chats": chats,
-		"page":  page,
-		"limit": limit,
-	})
+	response := gin.H{
+		"chats":    chats,
+		"limit":    limit,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		last := rows[len(rows)-1]
+		response["next_cursor"] = encodeCursor(last.LastActivityAt, last.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// loadChatList загружает чаты и их последние сообщения пачкой, сохраняя порядок rows
+func (h *ChatHandler) loadChatList(rows []chatListRow) ([]models.Chat, error) {
+	chats := make([]models.Chat, 0, len(rows))
+	if len(rows) == 0 {
+		return chats, nil
+	}
+
+	chatIDs := make([]uuid.UUID, 0, len(rows))
+	var messageIDs []uuid.UUID
+	for _, row := range rows {
+		chatIDs = append(chatIDs, row.ID)
+		if row.LastMessageID != nil {
+			messageIDs = append(messageIDs, *row.LastMessageID)
+		}
+	}
+
+	var found []models.Chat
+	if err := h.db.Preload("CreatedByUser").
+		Preload("Participants.User").
+		Where("id IN ?", chatIDs).
+		Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]models.Chat, len(found))
+	for _, chat := range found {
+		byID[chat.ID] = chat
+	}
+
+	messages := make(map[uuid.UUID]*models.Message, len(messageIDs))
+	if len(messageIDs) > 0 {
+		var last []models.Message
+		if err := h.db.Preload("User").Preload("Mentions").Where("id IN ?", messageIDs).Find(&last).Error; err != nil {
+			return nil, err
+		}
+		for i := range last {
+			messages[last[i].ID] = &last[i]
+		}
+	}
+
+	for _, row := range rows {
+		chat, ok := byID[row.ID]
+		if !ok {
+			continue
+		}
+		if row.LastMessageID != nil {
+			chat.LastMessage = messages[*row.LastMessageID]
+		}
+		lastActivity, unread, mentions := row.LastActivityAt, row.UnreadCount, row.UnreadMentionCount
+		chat.LastActivityAt = &lastActivity
+		chat.UnreadCount = &unread
+		chat.UnreadMentionCount = &mentions
+		chat.MutedUntil = row.MutedUntil
+		chats = append(chats, chat)
+	}
+	return chats, nil
 }
 
 func (h *ChatHandler) CreateChat(c *gin.Context) {
@@ -68,6 +217,12 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		return
 	}
 
+	// Личные чаты создаются через POST /users/:id/dm, сохраненные - через чатрулетку
+	if models.ChatType(req.Type) != models.ChatTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only group chats can be created here, use POST /users/:id/dm for private chats"})
+		return
+	}
+
 	// Создаем чат
 	chat := models.Chat{
 		Name:        req.Name,
@@ -81,11 +236,12 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		return
 	}
 
-	// Добавляем создателя как участника
+	// Добавляем создателя как владельца
 	chatUser := models.ChatUser{
 		ChatID:   chat.ID,
 		UserID:   userUUID,
 		IsActive: true,
+		Role:     models.ChatRoleOwner,
 	}
 
 	if err := h.db.Create(&chatUser).Error; err != nil {
@@ -99,6 +255,85 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 	c.JSON(http.StatusCreated, chat)
 }
 
+// GetOrCreateDirectChat возвращает личный чат с пользователем или создает его.
+// Уникальность пары обеспечивается индексом idx_chats_direct_pair
+func (h *ChatHandler) GetOrCreateDirectChat(c *gin.Context) {
+	userID := c.GetString("user_id")
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	otherUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if otherUUID == userUUID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot start a chat with yourself"})
+		return
+	}
+
+	// Гостям доступны только чаты чатрулетки
+	var other models.User
+	if err := h.db.Where("id = ? AND is_guest = ? AND blocked_at IS NULL", otherUUID, false).First(&other).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	low, high := models.DirectPair(userUUID, otherUUID)
+	created := false
+	var chat models.Chat
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		chat = models.Chat{
+			Name:           "Direct Chat",
+			Type:           models.ChatTypePrivate,
+			CreatedBy:      userUUID,
+			DirectUserLow:  &low,
+			DirectUserHigh: &high,
+		}
+
+		// При гонке второй запрос дождется коммита первого и ничего не вставит
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&chat)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Where("direct_user_low = ? AND direct_user_high = ?", low, high).First(&chat).Error; err != nil {
+				return err
+			}
+
+			// Если кто-то из двоих выходил из чата, возвращаем его
+			for _, id := range []uuid.UUID{userUUID, otherUUID} {
+				if _, err := addChatMember(tx, &models.ChatUser{ChatID: chat.ID, UserID: id, IsActive: true, Role: models.ChatRoleMember}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		created = true
+		return tx.Create(&[]models.ChatUser{
+			{ChatID: chat.ID, UserID: userUUID, IsActive: true, Role: models.ChatRoleOwner},
+			{ChatID: chat.ID, UserID: otherUUID, IsActive: true, Role: models.ChatRoleMember},
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get or create chat"})
+		return
+	}
+
+	h.db.Preload("CreatedByUser").Preload("Participants.User").First(&chat, "id = ?", chat.ID)
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, chat)
+}
+
 func (h *ChatHandler) GetChat(c *gin.Context) {
 	chatID := c.Param("id")
 	userID := c.GetString("user_id")
@@ -110,10 +345,10 @@ func (h *ChatHandler) GetChat(c *gin.Context) {
 		return
 	}
 
+	// Сообщения не подгружаются: лента с учетом скрытых сообщений и тредов - GET /chats/:id/messages
 	var chat models.Chat
 	if err := h.db.Preload("CreatedByUser").
 		Preload("Participants.User").
-		Preload("Messages.User").
 		First(&chat, "id = ?", chatID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
 		return
@@ -126,21 +361,56 @@ func (h *ChatHandler) DeleteChat(c *gin.Context) {
 	chatID := c.Param("id")
 	userID := c.GetString("user_id")
 
-	// Проверяем, является ли пользователь создателем чата
-	var chat models.Chat
-	if err := h.db.Where("id = ? AND created_by = ?", chatID, userID).First(&chat).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	// Удалить чат может только владелец
+	member, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionDelete)
+	if !ok {
 		return
 	}
+	chat := member.Chat
 
-	if err := h.db.Delete(&chat).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return deleteChatCascade(tx, chat.ID)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat"})
 		return
 	}
 
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "chat_deleted",
+		ChatID:  chat.ID.String(),
+		Payload: gin.H{"chat_id": chat.ID},
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
 }
 
+// deleteChatCascade удаляет чат вместе с сообщениями и участниками
+func deleteChatCascade(tx *gorm.DB, chatID uuid.UUID) error {
+	chatMessages := tx.Model(&models.Message{}).Select("id").Where("chat_id = ?", chatID)
+	if err := deleteMessageDependents(tx, chatMessages); err != nil {
+		return err
+	}
+	if err := tx.Where("message_id IN (?)", chatMessages).Delete(&models.HiddenMessage{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("chat_id = ?", chatID).Delete(&models.Message{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("chat_id = ?", chatID).Delete(&models.ScheduledMessage{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("chat_id = ?", chatID).Delete(&models.ChatUser{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("chat_id = ?", chatID).Delete(&models.ChatJoinRequest{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("chat_id = ?", chatID).Delete(&models.ChatInvite{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Chat{}, "id = ?", chatID).Error
+}
+
 func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 	// Получаем токен из query параметра
 	token := c.Query("token")
@@ -160,11 +430,17 @@ func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
 
 	// Проверяем, является ли пользователь участником чата
 	var chatUser models.ChatUser
-	if err := h.db.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&chatUser).Error; err != nil {
+	if err := h.db.Preload("User").Where("chat_id = ? AND user_id = ?", chatID, userID).First(&chatUser).Error; err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
 
+	// Гостям доступны только чаты чатрулетки
+	if chatUser.User.IsGuest && !middleware.RouletteChat(h.db, chatID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not available for guest accounts"})
+		return
+	}
+
 	websocket.HandleWebSocket(h.hub, c.Writer, c.Request, userID, chatID)
 }
 
@@ -176,7 +452,7 @@ func (h *ChatHandler) validateToken(tokenString string) (string, error) {
 
 	// Парсим JWT токен
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your-super-secret-jwt-key-change-in-production"), nil // Используем тот же секрет что в auth.go
+		return []byte(h.jwtSecret), nil
 	})
 
 	if err != nil || !token.Valid {
@@ -194,5 +470,11 @@ func (h *ChatHandler) validateToken(tokenString string) (string, error) {
 		return "", jwt.ErrInvalidKey
 	}
 
+	// Токены отозванных сессий не принимаем
+	sessionID, ok := claims["session_id"].(string)
+	if !ok || !middleware.SessionActive(h.db, sessionID, userID) {
+		return "", jwt.ErrTokenExpired
+	}
+
 	return userID, nil
 }
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errInviteUnusable     = errors.New("invite is revoked, expired or exhausted")
+	errAlreadyMember      = errors.New("already a member of this chat")
+	errJoinRequestPending = errors.New("join request already pending")
+)
+
+type CreateInviteRequest struct {
+	ExpiresIn        int  `json:"expires_in" binding:"omitempty,min=60"` // Секунды, 0 - бессрочно
+	MaxUses          int  `json:"max_uses" binding:"omitempty,min=1"`    // 0 - без ограничений
+	RequiresApproval bool `json:"requires_approval"`
+}
+
+// CreateInvite создает ссылку-приглашение в групповой чат
+func (h *ChatHandler) CreateInvite(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageInvites)
+	if !ok {
+		return
+	}
+
+	if actor.Chat.Type != models.ChatTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invites are only available for group chats"})
+		return
+	}
+
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invite := models.ChatInvite{
+		ChatID:           actor.ChatID,
+		CreatedBy:        actor.UserID,
+		MaxUses:          req.MaxUses,
+		RequiresApproval: req.RequiresApproval,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if err := h.db.Create(&invite).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// GetInvites возвращает ссылки-приглашения чата
+func (h *ChatHandler) GetInvites(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	if _, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageInvites); !ok {
+		return
+	}
+
+	var invites []models.ChatInvite
+	if err := h.db.Where("chat_id = ?", chatID).Order("created_at DESC").Find(&invites).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// RevokeInvite отзывает ссылку-приглашение
+func (h *ChatHandler) RevokeInvite(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	if _, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageInvites); !ok {
+		return
+	}
+
+	result := h.db.Model(&models.ChatInvite{}).
+		Where("id = ? AND chat_id = ? AND revoked_at IS NULL", c.Param("invite_id"), chatID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// PreviewInvite показывает чат по ссылке без вступления
+func (h *ChatHandler) PreviewInvite(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var invite models.ChatInvite
+	if err := h.db.Preload("Chat").Where("code = ?", c.Param("code")).First(&invite).Error; err != nil || !invite.IsUsable() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found or expired"})
+		return
+	}
+
+	var memberCount, isMember int64
+	if err := h.db.Model(&models.ChatUser{}).Where("chat_id = ?", invite.ChatID).Count(&memberCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat"})
+		return
+	}
+	if err := h.db.Model(&models.ChatUser{}).Where("chat_id = ? AND user_id = ?", invite.ChatID, userID).Count(&isMember).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chat_id":           invite.ChatID,
+		"name":              invite.Chat.Name,
+		"description":       invite.Chat.Description,
+		"member_count":      memberCount,
+		"requires_approval": invite.RequiresApproval,
+		"is_member":         isMember > 0,
+		"expires_at":        invite.ExpiresAt,
+	})
+}
+
+// JoinByInvite вступление в чат по ссылке. Если ссылка требует одобрения,
+// создается заявка, которую рассматривают админы чата
+func (h *ChatHandler) JoinByInvite(c *gin.Context) {
+	userID := c.GetString("user_id")
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var invite models.ChatInvite
+	if err := h.db.Where("code = ?", c.Param("code")).First(&invite).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found or expired"})
+		return
+	}
+
+	var count int64
+	if err := h.db.Model(&models.ChatUser{}).Where("chat_id = ? AND user_id = ?", invite.ChatID, userID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join chat"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already a member of this chat"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if invite.RequiresApproval {
+		h.createJoinRequest(c, &invite, &user)
+		return
+	}
+
+	var msg *models.Message
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := consumeInvite(tx, invite.ID); err != nil {
+			return err
+		}
+
+		// Параллельный запрос мог уже добавить пользователя: тогда ссылка не расходуется
+		inserted, err := addChatMember(tx, &models.ChatUser{
+			ChatID:   invite.ChatID,
+			UserID:   userUUID,
+			IsActive: true,
+			Role:     models.ChatRoleMember,
+			AddedBy:  &invite.CreatedBy,
+		})
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return errAlreadyMember
+		}
+
+		msg, err = createSystemMessage(tx, invite.ChatID, userUUID,
+			fmt.Sprintf("%s joined via invite link", user.Username))
+		return err
+	})
+	if errors.Is(err, errInviteUnusable) {
+		c.JSON(http.StatusGone, gin.H{"error": "Invite is no longer valid"})
+		return
+	}
+	if errors.Is(err, errAlreadyMember) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already a member of this chat"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join chat"})
+		return
+	}
+
+	h.broadcastSystemMessage(*msg)
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "member_added",
+		ChatID: invite.ChatID.String(),
+		Payload: gin.H{
+			"chat_id":   invite.ChatID,
+			"user_id":   userUUID,
+			"invite_id": invite.ID,
+		},
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Joined chat successfully",
+		"chat_id": invite.ChatID,
+	})
+}
+
+func (h *ChatHandler) createJoinRequest(c *gin.Context, invite *models.ChatInvite, user *models.User) {
+	var pending int64
+	if err := h.db.Model(&models.ChatJoinRequest{}).
+		Where("chat_id = ? AND user_id = ? AND status = ?", invite.ChatID, user.ID, models.JoinRequestPending).
+		Count(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create join request"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Join request already pending"})
+		return
+	}
+
+	request := models.ChatJoinRequest{
+		ChatID:   invite.ChatID,
+		InviteID: invite.ID,
+		UserID:   user.ID,
+		Status:   models.JoinRequestPending,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := consumeInvite(tx, invite.ID); err != nil {
+			return err
+		}
+		// Вторая заявка параллельным запросом упирается в уникальный индекс ожидающих заявок
+		result := tx.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "chat_id"}, {Name: "user_id"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "status = 'pending'"}}},
+			DoNothing:   true,
+		}).Create(&request)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return errJoinRequestPending
+		}
+		return result.Error
+	})
+	if errors.Is(err, errInviteUnusable) {
+		c.JSON(http.StatusGone, gin.H{"error": "Invite is no longer valid"})
+		return
+	}
+	if errors.Is(err, errJoinRequestPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Join request already pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create join request"})
+		return
+	}
+
+	// Заявку видят только те, кто может ее рассмотреть, в каком бы чате они ни были
+	var reviewers []string
+	if err := h.db.Model(&models.ChatUser{}).
+		Where("chat_id = ? AND role IN ?", invite.ChatID, []models.ChatRole{models.ChatRoleOwner, models.ChatRoleAdmin}).
+		Pluck("user_id", &reviewers).Error; err != nil {
+		log.Printf("Failed to fetch join request reviewers for chat %s: %v", invite.ChatID, err)
+	}
+	request.User = *user
+	if len(reviewers) > 0 {
+		h.hub.Broadcast <- websocket.Message{
+			Type:       "join_request_created",
+			ChatID:     invite.ChatID.String(),
+			Recipients: reviewers,
+			AllChats:   true,
+			Payload:    request,
+		}
+	}
+
+	c.JSON(http.StatusAccepted, request)
+}
+
+// GetJoinRequests возвращает ожидающие заявки на вступление
+func (h *ChatHandler) GetJoinRequests(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	if _, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageInvites); !ok {
+		return
+	}
+
+	var requests []models.ChatJoinRequest
+	if err := h.db.Preload("User").
+		Where("chat_id = ? AND status = ?", chatID, models.JoinRequestPending).
+		Order("created_at").Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch join requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// ApproveJoinRequest одобряет заявку и добавляет пользователя в чат
+func (h *ChatHandler) ApproveJoinRequest(c *gin.Context) {
+	h.reviewJoinRequest(c, models.JoinRequestApproved)
+}
+
+// RejectJoinRequest отклоняет заявку
+func (h *ChatHandler) RejectJoinRequest(c *gin.Context) {
+	h.reviewJoinRequest(c, models.JoinRequestRejected)
+}
+
+func (h *ChatHandler) reviewJoinRequest(c *gin.Context, status models.JoinRequestStatus) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageInvites)
+	if !ok {
+		return
+	}
+
+	var request models.ChatJoinRequest
+	if err := h.db.Preload("User").
+		Where("id = ? AND chat_id = ?", c.Param("request_id"), chatID).First(&request).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found"})
+		return
+	}
+
+	var msg *models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Заявку может рассмотреть только один админ
+		now := time.Now()
+		result := tx.Model(&models.ChatJoinRequest{}).
+			Where("id = ? AND status = ?", request.ID, models.JoinRequestPending).
+			Updates(map[string]interface{}{
+				"status":      status,
+				"reviewed_by": actor.UserID,
+				"reviewed_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		request.Status = status
+		request.ReviewedBy = &actor.UserID
+		request.ReviewedAt = &now
+
+		if status != models.JoinRequestApproved {
+			return nil
+		}
+
+		inserted, err := addChatMember(tx, &models.ChatUser{
+			ChatID:   request.ChatID,
+			UserID:   request.UserID,
+			IsActive: true,
+			Role:     models.ChatRoleMember,
+			AddedBy:  &actor.UserID,
+		})
+		if err != nil || !inserted {
+			return err // Уже участник: заявка одобрена, добавлять некого
+		}
+
+		msg, err = createSystemMessage(tx, request.ChatID, actor.UserID,
+			fmt.Sprintf("%s approved %s", actor.User.Username, request.User.Username))
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Join request already reviewed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review join request"})
+		return
+	}
+
+	if msg != nil {
+		h.broadcastSystemMessage(*msg)
+		h.hub.Broadcast <- websocket.Message{
+			Type:   "member_added",
+			ChatID: chatID,
+			Payload: gin.H{
+				"chat_id":  request.ChatID,
+				"user_id":  request.UserID,
+				"added_by": actor.UserID,
+			},
+		}
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// consumeInvite атомарно засчитывает использование ссылки, если она еще действует
+func consumeInvite(tx *gorm.DB, inviteID uuid.UUID) error {
+	result := tx.Model(&models.ChatInvite{}).
+		Where("id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND (max_uses = 0 OR use_count < max_uses)",
+			inviteID, time.Now()).
+		Update("use_count", gorm.Expr("use_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInviteUnusable
+	}
+	return nil
+}
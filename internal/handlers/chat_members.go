@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AddMembersRequest struct {
+	UserIDs []string `json:"user_ids" binding:"required,min=1,max=50"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type UpdateChatRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type MuteChatRequest struct {
+	MutedUntil *time.Time `json:"muted_until"` // null - включить уведомления
+}
+
+// AddMembers добавляет пользователей в групповой чат
+func (h *ChatHandler) AddMembers(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionAddMembers)
+	if !ok {
+		return
+	}
+
+	if actor.Chat.Type != models.ChatTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Members can only be added to group chats"})
+		return
+	}
+
+	var req AddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Повторы в списке не считаются ошибкой: каждый пользователь добавляется один раз
+	var ids []uuid.UUID
+	seen := make(map[uuid.UUID]bool, len(req.UserIDs))
+	for _, id := range req.UserIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID: " + id})
+			return
+		}
+		if !seen[parsed] {
+			seen[parsed] = true
+			ids = append(ids, parsed)
+		}
+	}
+
+	// Гостям доступны только чаты чатрулетки, заблокированных не добавляем
+	var users []models.User
+	if err := h.db.Where("id IN ? AND is_guest = ? AND blocked_at IS NULL", ids, false).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	if len(users) != len(ids) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some users not found or cannot be added"})
+		return
+	}
+
+	var added []models.User
+	var systemMessages []models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range users {
+			inserted, err := addChatMember(tx, &models.ChatUser{
+				ChatID:   actor.ChatID,
+				UserID:   user.ID,
+				IsActive: true,
+				Role:     models.ChatRoleMember,
+				AddedBy:  &actor.UserID,
+			})
+			if err != nil {
+				return err
+			}
+			if !inserted {
+				continue // Уже участник
+			}
+
+			msg, err := createSystemMessage(tx, actor.ChatID, actor.UserID,
+				fmt.Sprintf("%s added %s", actor.User.Username, user.Username))
+			if err != nil {
+				return err
+			}
+			added = append(added, user)
+			systemMessages = append(systemMessages, *msg)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add members"})
+		return
+	}
+
+	for i, user := range added {
+		h.broadcastSystemMessage(systemMessages[i])
+		h.hub.Broadcast <- websocket.Message{
+			Type:   "member_added",
+			ChatID: chatID,
+			Payload: gin.H{
+				"chat_id":  actor.ChatID,
+				"user_id":  user.ID,
+				"added_by": actor.UserID,
+			},
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"added":   added,
+		"skipped": len(users) - len(added),
+	})
+}
+
+// RemoveMember удаляет участника из группового чата
+func (h *ChatHandler) RemoveMember(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+	targetID := c.Param("user_id")
+
+	if targetID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use leave to remove yourself"})
+		return
+	}
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionRemoveMembers)
+	if !ok {
+		return
+	}
+
+	if actor.Chat.Type != models.ChatTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Members can only be removed from group chats"})
+		return
+	}
+
+	var target models.ChatUser
+	if err := h.db.Preload("User").Where("chat_id = ? AND user_id = ?", chatID, targetID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	// Админ может удалять только обычных участников
+	if !actor.Role.Outranks(target.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient chat role"})
+		return
+	}
+
+	var msg *models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&target).Error; err != nil {
+			return err
+		}
+		var err error
+		msg, err = createSystemMessage(tx, actor.ChatID, actor.UserID,
+			fmt.Sprintf("%s removed %s", actor.User.Username, target.User.Username))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	h.broadcastSystemMessage(*msg)
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "member_removed",
+		ChatID: chatID,
+		Payload: gin.H{
+			"chat_id":    actor.ChatID,
+			"user_id":    target.UserID,
+			"removed_by": actor.UserID,
+		},
+	}
+	h.hub.Disconnect(targetID, chatID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// LeaveChat выход из чата. Если выходит владелец, права переходят старейшему
+// админу (или участнику), а опустевший чат удаляется
+func (h *ChatHandler) LeaveChat(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var member models.ChatUser
+	if err := h.db.Preload("User").Preload("Chat").
+		Where("chat_id = ? AND user_id = ?", chatID, userID).First(&member).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var msg *models.Message
+	var newOwner *models.ChatUser
+	chatDeleted := false
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&member).Error; err != nil {
+			return err
+		}
+
+		var remaining int64
+		if err := tx.Model(&models.ChatUser{}).Where("chat_id = ?", member.ChatID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining == 0 {
+			chatDeleted = true
+			return deleteChatCascade(tx, member.ChatID)
+		}
+
+		if member.Role == models.ChatRoleOwner {
+			var successor models.ChatUser
+			if err := tx.Where("chat_id = ?", member.ChatID).
+				Order("CASE WHEN role = 'admin' THEN 0 ELSE 1 END, joined_at").
+				First(&successor).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&successor).Update("role", models.ChatRoleOwner).Error; err != nil {
+				return err
+			}
+			newOwner = &successor
+		}
+
+		var err error
+		msg, err = createSystemMessage(tx, member.ChatID, member.UserID,
+			fmt.Sprintf("%s left the chat", member.User.Username))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave chat"})
+		return
+	}
+
+	h.hub.Disconnect(userID, chatID)
+	if chatDeleted {
+		c.JSON(http.StatusOK, gin.H{"message": "Left chat, chat deleted"})
+		return
+	}
+
+	h.broadcastSystemMessage(*msg)
+	payload := gin.H{"chat_id": member.ChatID, "user_id": member.UserID}
+	if newOwner != nil {
+		payload["new_owner_id"] = newOwner.UserID
+	}
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "member_left",
+		ChatID:  chatID,
+		Payload: payload,
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left chat successfully"})
+}
+
+// UpdateMemberRole меняет роль участника. Назначение владельцем передает права,
+// прежний владелец становится админом
+func (h *ChatHandler) UpdateMemberRole(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+	targetID := c.Param("user_id")
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionManageRoles)
+	if !ok {
+		return
+	}
+
+	if actor.Chat.Type != models.ChatTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Roles can only be changed in group chats"})
+		return
+	}
+
+	var req UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.ChatRole(req.Role)
+	if role != models.ChatRoleOwner && role != models.ChatRoleAdmin && role != models.ChatRoleMember {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown chat role"})
+		return
+	}
+
+	if targetID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	var target models.ChatUser
+	if err := h.db.Preload("User").Where("chat_id = ? AND user_id = ?", chatID, targetID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	target.Role = role
+
+	var msg *models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if role == models.ChatRoleOwner {
+			if err := tx.Model(actor).Update("role", models.ChatRoleAdmin).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&target).Update("role", role).Error; err != nil {
+			return err
+		}
+
+		var err error
+		msg, err = createSystemMessage(tx, actor.ChatID, actor.UserID,
+			fmt.Sprintf("%s made %s %s", actor.User.Username, target.User.Username, role))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	h.broadcastSystemMessage(*msg)
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "member_role_changed",
+		ChatID: chatID,
+		Payload: gin.H{
+			"chat_id":    actor.ChatID,
+			"user_id":    target.UserID,
+			"role":       role,
+			"changed_by": actor.UserID,
+		},
+	}
+
+	c.JSON(http.StatusOK, target)
+}
+
+// UpdateChat переименовывает чат и меняет описание
+func (h *ChatHandler) UpdateChat(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	actor, ok := h.requireChatPermission(c, chatID, userID, models.ChatActionRename)
+	if !ok {
+		return
+	}
+
+	var req UpdateChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat := actor.Chat
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		if *req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		chat.Name = *req.Name
+		updates["name"] = chat.Name
+	}
+	if req.Description != nil {
+		chat.Description = *req.Description
+		updates["description"] = chat.Description
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	var msg *models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&chat).Updates(updates).Error; err != nil {
+			return err
+		}
+		if req.Name == nil {
+			return nil
+		}
+
+		var err error
+		msg, err = createSystemMessage(tx, chat.ID, actor.UserID,
+			fmt.Sprintf("%s renamed the chat to \"%s\"", actor.User.Username, chat.Name))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	if msg != nil {
+		h.broadcastSystemMessage(*msg)
+	}
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "chat_updated",
+		ChatID:  chatID,
+		Payload: chat,
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// MuteChat выключает уведомления о новых сообщениях чата до muted_until или включает их обратно.
+// Настройка личная: остальные участники о ней не узнают. Пока чат выключен, thread_reply без упоминания не приходит
+func (h *ChatHandler) MuteChat(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var req MuteChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MutedUntil != nil && !req.MutedUntil.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "muted_until must be in the future"})
+		return
+	}
+
+	result := h.db.Model(&models.ChatUser{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Update("muted_until", req.MutedUntil)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chat_id":     chatID,
+		"muted_until": req.MutedUntil,
+	})
+}
+
+// requireChatPermission загружает участника (с чатом и пользователем) и проверяет,
+// что его роль позволяет действие. При отказе сам пишет ответ
+func (h *ChatHandler) requireChatPermission(c *gin.Context, chatID, userID string, action models.ChatAction) (*models.ChatUser, bool) {
+	var member models.ChatUser
+	err := h.db.Preload("User").Preload("Chat").
+		Where("chat_id = ? AND user_id = ?", chatID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access"})
+		return nil, false
+	}
+
+	if !member.Role.Can(action) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient chat role"})
+		return nil, false
+	}
+
+	return &member, true
+}
+
+// broadcastSystemMessage рассылает служебное сообщение как обычное новое сообщение
+func (h *ChatHandler) broadcastSystemMessage(msg models.Message) {
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "new_message",
+		ChatID:  msg.ChatID.String(),
+		Payload: msg,
+	}
+}
+
+// createSystemMessage сохраняет служебное сообщение от имени пользователя, совершившего действие
+func createSystemMessage(tx *gorm.DB, chatID, actorID uuid.UUID, content string) (*models.Message, error) {
+	msg := models.Message{
+		ChatID:  chatID,
+		UserID:  actorID,
+		Type:    models.MessageTypeSystem,
+		Content: content,
+		Status:  models.MessageStatusDelivered,
+	}
+	if err := tx.Create(&msg).Error; err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// addChatMember добавляет участника в чат. Уникальный индекс (chat_id, user_id) не дает
+// параллельным запросам добавить его дважды: false, если пользователь уже в чате
+func addChatMember(tx *gorm.DB, member *models.ChatUser) (bool, error) {
+	result := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "user_id"}},
+		DoNothing: true,
+	}).Create(member)
+	return result.RowsAffected > 0, result.Error
+}
@@ -68,6 +68,7 @@ func (h *ChatrouletteHandler) FindRandomUser(c *gin.Context) {
 		Description: "Temporary chat for chatroulette",
 		Type:        models.ChatTypePrivate,
 		CreatedBy:   currentUserUUID,
+		IsRoulette:  true,
 	}
 
 	if err := h.db.Create(&chat).Error; err != nil {
@@ -81,6 +82,7 @@ func (h *ChatrouletteHandler) FindRandomUser(c *gin.Context) {
 			ChatID:   chat.ID,
 			UserID:   currentUserUUID,
 			IsActive: true,
+			Role:     models.ChatRoleOwner,
 		},
 		{
 			ChatID:   chat.ID,
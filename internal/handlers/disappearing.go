@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const expiredBatchSize = 100 // Сколько исчезнувших сообщений обрабатывается за одну транзакцию
+
+type SetMessageTTLRequest struct {
+	TTL *int `json:"ttl" binding:"required"` // Секунды, 0 - выключить
+}
+
+// SetMessageTTL включает, меняет или выключает исчезающие сообщения в чате.
+// Менять может любой участник, изменение объявляется служебным сообщением.
+// Действует на сообщения, отправленные после изменения
+func (h *ChatHandler) SetMessageTTL(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var actor models.ChatUser
+	if err := h.db.Preload("User").Preload("Chat").
+		Where("chat_id = ? AND user_id = ?", chatID, userID).First(&actor).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req SetMessageTTLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidMessageTTL(*req.TTL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ttl must be 0 or between %d and %d seconds", models.MinMessageTTL, models.MaxMessageTTL)})
+		return
+	}
+
+	chat := actor.Chat
+	if chat.MessageTTL == *req.TTL {
+		c.JSON(http.StatusOK, chat)
+		return
+	}
+	chat.MessageTTL = *req.TTL
+
+	content := fmt.Sprintf("%s turned off disappearing messages", actor.User.Username)
+	if chat.MessageTTL > 0 {
+		content = fmt.Sprintf("%s set disappearing messages to %s", actor.User.Username, formatTTL(chat.MessageTTL))
+	}
+
+	var msg *models.Message
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&chat).Update("message_ttl", chat.MessageTTL).Error; err != nil {
+			return err
+		}
+		var err error
+		msg, err = createSystemMessage(tx, chat.ID, actor.UserID, content)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	h.broadcastSystemMessage(*msg)
+	h.hub.Broadcast <- websocket.Message{
+		Type:    "chat_updated",
+		ChatID:  chatID,
+		Payload: chat,
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// formatTTL записывает время жизни самой крупной единицей, которой оно делится нацело
+func formatTTL(ttl int) string {
+	units := []struct {
+		seconds int
+		name    string
+	}{
+		{7 * 24 * 60 * 60, "week"},
+		{24 * 60 * 60, "day"},
+		{60 * 60, "hour"},
+		{60, "minute"},
+		{1, "second"},
+	}
+	for _, unit := range units {
+		if ttl%unit.seconds != 0 {
+			continue
+		}
+		n := ttl / unit.seconds
+		if n == 1 {
+			return "1 " + unit.name
+		}
+		return fmt.Sprintf("%d %ss", n, unit.name)
+	}
+	return fmt.Sprintf("%d seconds", ttl)
+}
+
+// ReapExpiredMessages превращает исчезнувшие сообщения в "надгробия", удаляет их файлы
+// и рассылает message_expired. Строки берутся через SKIP LOCKED, поэтому несколько
+// экземпляров сервера не обработают одно сообщение дважды
+func (h *MessageHandler) ReapExpiredMessages() error {
+	for {
+		var (
+			expired []models.Message
+			media   []string
+		)
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("expires_at <= ? AND deleted_at IS NULL", time.Now()).
+				Order("expires_at").Limit(expiredBatchSize).
+				Find(&expired).Error; err != nil {
+				return err
+			}
+			ids := make([]uuid.UUID, len(expired))
+			for i := range expired {
+				ids[i] = expired[i].ID
+				if expired[i].MediaURL != "" {
+					media = append(media, expired[i].MediaURL)
+				}
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			if err := deleteMessageDependents(tx, ids); err != nil {
+				return err
+			}
+			for i := range expired {
+				expired[i].MarkAsExpired()
+				if err := saveTombstone(tx, &expired[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		removeUnusedMedia(h.db, h.cfg.UploadPath, media)
+		for i := range expired {
+			h.hub.Broadcast <- websocket.Message{
+				Type:   "message_expired",
+				ChatID: expired[i].ChatID.String(),
+				Payload: gin.H{
+					"message_id": expired[i].ID,
+					"chat_id":    expired[i].ChatID,
+					"expired_at": expired[i].ExpiresAt,
+				},
+			}
+		}
+
+		if len(expired) < expiredBatchSize {
+			return nil
+		}
+	}
+}
+
+// removeUnusedMedia удаляет загруженные файлы и записи о них, если на файл больше не ссылается ни одно сообщение.
+// Пересланные копии, отложенные сообщения и стикеры ссылаются на тот же файл, поэтому он остается, пока жив хоть один из них
+func removeUnusedMedia(db *gorm.DB, uploadPath string, mediaURLs []string) {
+	for _, mediaURL := range mediaURLs {
+		path, ok := uploadedFilePath(uploadPath, mediaURL)
+		if !ok {
+			continue
+		}
+
+		var messages, scheduled, stickers int64
+		if err := db.Model(&models.Message{}).Where("media_url = ?", mediaURL).Count(&messages).Error; err != nil || messages > 0 {
+			continue
+		}
+		if err := db.Model(&models.Sticker{}).Where("media_url = ?", mediaURL).Count(&stickers).Error; err != nil || stickers > 0 {
+			continue
+		}
+		if err := db.Model(&models.ScheduledMessage{}).
+			Where("media_url = ? AND status IN ?", mediaURL, []models.ScheduledStatus{models.ScheduledPending, models.ScheduledSending}).
+			Count(&scheduled).Error; err != nil || scheduled > 0 {
+			continue
+		}
+
+		// Сначала запись о загрузке: без нее на файл уже нельзя сослаться в новом сообщении
+		if err := db.Where("url = ?", mediaURL).Delete(&models.Upload{}).Error; err != nil {
+			log.Printf("Failed to remove upload record %s: %v", mediaURL, err)
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove expired media %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"swirl-backend/internal/mailer"
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errEmailTaken = errors.New("email already in use")
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password"` // Не требуется, если пароль не задан
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ChangeEmail запрашивает привязку нового адреса почты.
+// Текущий адрес остается в профиле, пока новый не будет подтвержден
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Смена адреса позволяет восстановить пароль, поэтому требуем текущий пароль
+	if !user.Passwordless && user.CheckPassword(req.Password) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if user.Email != nil && user.EmailVerified && strings.EqualFold(*user.Email, email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if h.emailTaken(email, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	if err := h.sendEmailVerification(&user, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Verification email sent",
+		"pending_email": email,
+	})
+}
+
+// VerifyEmail подтверждает адрес по одноразовому токену из письма
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Атомарно помечаем токен использованным
+		now := time.Now()
+		hash := models.HashSecretToken(req.Token)
+		result := tx.Model(&models.EmailVerificationToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var token models.EmailVerificationToken
+		if err := tx.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+			return err
+		}
+
+		// Адрес мог занять кто-то другой, пока письмо шло
+		var count int64
+		if err := tx.Model(&models.User{}).
+			Where("LOWER(email) = LOWER(?) AND id <> ?", token.Email, token.UserID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errEmailTaken
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+			"email":          token.Email,
+			"email_verified": true,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.First(&user, "id = ?", token.UserID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if errors.Is(err, errEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+		"user":    user.Account(),
+	})
+}
+
+// sendEmailVerification создает токен подтверждения для адреса и отправляет письмо.
+// Предыдущие неиспользованные токены пользователя перестают действовать
+func (h *AuthHandler) sendEmailVerification(user *models.User, email string) error {
+	token, hash, err := models.NewSecretToken()
+	if err != nil {
+		return err
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.EmailVerificationToken{
+			UserID:    user.ID,
+			Email:     email,
+			TokenHash: hash,
+			ExpiresAt: now.Add(h.cfg.EmailVerificationTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimRight(h.cfg.AppURL, "/"), token)
+	return h.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Swirl: подтверждение почты",
+		Body: fmt.Sprintf("Привет, %s!\n\nЧтобы подтвердить адрес почты, перейди по ссылке:\n%s\n\nСсылка действует %s.",
+			user.Username, link, h.cfg.EmailVerificationTTL),
+	})
+}
+
+// findUserByLogin ищет пользователя по имени или подтвержденному email без учета регистра
+func (h *AuthHandler) findUserByLogin(login string) (*models.User, error) {
+	login = strings.TrimSpace(login)
+
+	var user models.User
+	query := h.db.Where("LOWER(username) = LOWER(?)", login)
+	if strings.Contains(login, "@") {
+		query = h.db.Where("LOWER(email) = LOWER(?) AND email_verified = ?", login, true)
+	}
+
+	if err := query.First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// usernameTaken проверяет, занято ли имя другим пользователем (без учета регистра)
+func (h *AuthHandler) usernameTaken(username string, exceptUserID uuid.UUID) bool {
+	var count int64
+	h.db.Model(&models.User{}).
+		Where("LOWER(username) = LOWER(?) AND id <> ?", username, exceptUserID).
+		Count(&count)
+	return count > 0
+}
+
+// emailTaken проверяет, привязан ли адрес к другому пользователю (без учета регистра)
+func (h *AuthHandler) emailTaken(email string, exceptUserID uuid.UUID) bool {
+	var count int64
+	h.db.Model(&models.User{}).
+		Where("LOWER(email) = LOWER(?) AND id <> ?", email, exceptUserID).
+		Count(&count)
+	return count > 0
+}
+
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(email)
+}
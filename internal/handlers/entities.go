@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"swirl-backend/internal/models"
+)
+
+const (
+	parseModeMarkdown = "markdown" // Разметка в тексте: **жирный**, `код`, [текст](ссылка)...
+	maxEntities       = 100        // Сколько фрагментов форматирования может быть в сообщении
+	maxLinkLength     = 2048
+)
+
+// linkSchemes схемы, которые допускаются в ссылках; остальные (javascript:, data: ...) вырезаются
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// urlPattern адреса, которые находятся в тексте без разметки
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// formatContent превращает разметку в форматирование (parse_mode), проверяет переданные
+// клиентом фрагменты и находит в тексте адреса. Результат остается в m.Content и m.Entities
+func formatContent(m *messageContent) error {
+	switch m.ParseMode {
+	case "":
+	case parseModeMarkdown:
+		if len(m.Entities) > 0 {
+			return &messageError{Code: codeInvalidEntities, Field: "entities", Message: "entities cannot be combined with parse_mode"}
+		}
+		m.Content, m.Entities = parseMarkdown(m.Content)
+	default:
+		return &messageError{Code: codeInvalidEntities, Field: "parse_mode", Message: "Unknown parse_mode"}
+	}
+	m.ParseMode = ""
+
+	entities, err := normalizeEntities(m.Content, m.Entities)
+	if err != nil {
+		return err
+	}
+	entities = detectURLs(m.Content, entities)
+	if len(entities) > maxEntities {
+		return &messageError{Code: codeInvalidEntities, Field: "entities", Message: "Too many entities"}
+	}
+	m.Entities = entities
+	return nil
+}
+
+// normalizeEntities проверяет фрагменты по тексту: известный вид, границы внутри текста
+// и не посреди символа, вложенность без частичных пересечений, ничего внутри кода.
+// Адреса (url) сервер находит сам, ссылки с опасной схемой вырезаются
+func normalizeEntities(content string, entities models.MessageEntities) (models.MessageEntities, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+	invalid := func(message string) error {
+		return &messageError{Code: codeInvalidEntities, Field: "entities", Message: message}
+	}
+
+	// Допустимые границы: начало каждого символа и конец текста
+	text := []rune(content)
+	boundaries := make(map[int]bool, len(text)+1)
+	units := 0
+	for _, r := range text {
+		boundaries[units] = true
+		units += utf16RuneLength(r)
+	}
+	boundaries[units] = true
+
+	result := make(models.MessageEntities, 0, len(entities))
+	for _, entity := range entities {
+		if !entity.Type.IsKnown() {
+			return nil, invalid("Unknown entity type")
+		}
+		if entity.Offset < 0 || entity.Length <= 0 || entity.End() > units ||
+			!boundaries[entity.Offset] || !boundaries[entity.End()] {
+			return nil, invalid("Entity is out of range")
+		}
+		switch entity.Type {
+		case models.EntityURL:
+			continue
+		case models.EntityTextLink:
+			link, ok := sanitizeLink(entity.URL)
+			if !ok {
+				continue
+			}
+			entity.URL = link
+		default:
+			entity.URL = ""
+		}
+		result = append(result, entity)
+	}
+
+	sortEntities(result)
+	var open []models.MessageEntity
+	for _, entity := range result {
+		for len(open) > 0 && open[len(open)-1].End() <= entity.Offset {
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			parent := open[len(open)-1]
+			if entity.End() > parent.End() {
+				return nil, invalid("Entities must not partially overlap")
+			}
+			if parent.Type.IsCode() {
+				return nil, invalid("Code cannot contain other entities")
+			}
+		}
+		open = append(open, entity)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// sortEntities упорядочивает фрагменты так, что внешний идет перед вложенным
+func sortEntities(entities models.MessageEntities) {
+	sort.SliceStable(entities, func(i, j int) bool {
+		a, b := entities[i], entities[j]
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		if a.Length != b.Length {
+			return a.Length > b.Length
+		}
+		return !a.Type.IsCode() && b.Type.IsCode()
+	})
+}
+
+// detectURLs добавляет фрагменты url для адресов в тексте. Адреса внутри кода и ссылок
+// и те, что пересекли бы границу другого фрагмента, пропускаются
+func detectURLs(content string, entities models.MessageEntities) models.MessageEntities {
+	matches := urlPattern.FindAllStringIndex(content, -1)
+	if len(matches) == 0 {
+		return entities
+	}
+
+	found := false
+	for _, match := range matches {
+		raw := trimURLPunctuation(content[match[0]:match[1]])
+		offset := utf16Length([]rune(content[:match[0]]))
+		candidate := models.MessageEntity{
+			Type:   models.EntityURL,
+			Offset: offset,
+			Length: utf16Length([]rune(raw)),
+		}
+		if _, ok := sanitizeLink(raw); !ok || !fitsEntities(candidate, entities) {
+			continue
+		}
+		entities = append(entities, candidate)
+		found = true
+	}
+	if found {
+		sortEntities(entities)
+	}
+	return entities
+}
+
+// fitsEntities проверяет, что адрес не внутри кода или ссылки и не пересекает границы фрагментов
+func fitsEntities(candidate models.MessageEntity, entities models.MessageEntities) bool {
+	for _, entity := range entities {
+		if entity.End() <= candidate.Offset || candidate.End() <= entity.Offset {
+			continue
+		}
+		inside := entity.Offset <= candidate.Offset && candidate.End() <= entity.End()
+		if !inside || entity.Type.IsCode() || entity.Type == models.EntityTextLink || entity.Type == models.EntityURL {
+			return false
+		}
+	}
+	return true
+}
+
+// trimURLPunctuation отрезает знаки препинания, которыми заканчивается предложение,
+// а не адрес. Закрывающая скобка остается, если открыта в самом адресе
+func trimURLPunctuation(raw string) string {
+	for len(raw) > 0 {
+		last := raw[len(raw)-1]
+		if last == ')' && strings.Count(raw, "(") >= strings.Count(raw, ")") {
+			break
+		}
+		if !strings.ContainsRune(".,:;!?'\")]}", rune(last)) {
+			break
+		}
+		raw = raw[:len(raw)-1]
+	}
+	return raw
+}
+
+// sanitizeLink приводит адрес ссылки к безопасному виду: только http(s) с хостом и mailto.
+// Адрес без схемы считается https
+func sanitizeLink(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxLinkLength || strings.IndexFunc(raw, unicode.IsSpace) >= 0 {
+		return "", false
+	}
+	link, err := url.Parse(raw)
+	if err == nil && link.Scheme == "" {
+		link, err = url.Parse("https://" + raw)
+	}
+	if err != nil {
+		return "", false
+	}
+	link.Scheme = strings.ToLower(link.Scheme)
+	if !linkSchemes[link.Scheme] {
+		return "", false
+	}
+	if link.Scheme == "mailto" {
+		if link.Opaque == "" {
+			return "", false
+		}
+	} else if link.Host == "" {
+		return "", false
+	}
+	return link.String(), true
+}
+
+// markdownStyles парные маркеры разметки; более длинные проверяются раньше
+var markdownStyles = []struct {
+	marker string
+	entity models.EntityType
+}{
+	{"**", models.EntityBold},
+	{"__", models.EntityUnderline},
+	{"~~", models.EntityStrikethrough},
+	{"||", models.EntitySpoiler},
+	{"*", models.EntityItalic},
+}
+
+// markdownParser разбирает разметку: **жирный**, *курсив*, __подчеркнутый__, ~~зачеркнутый~~,
+// ||спойлер||, `код`, ```блок кода```, [текст](ссылка). \ перед спецсимволом выводит его как есть.
+// Незакрытый маркер остается обычным текстом
+type markdownParser struct {
+	text     []rune
+	out      []rune
+	units    int // Длина out в единицах UTF-16
+	entities models.MessageEntities
+}
+
+// parseMarkdown возвращает текст без разметки и фрагменты форматирования
+func parseMarkdown(content string) (string, models.MessageEntities) {
+	p := markdownParser{text: []rune(content)}
+	p.parse(0, len(p.text))
+	sortEntities(p.entities)
+	return string(p.out), p.entities
+}
+
+func (p *markdownParser) parse(from, to int) {
+	for i := from; i < to; {
+		if next, ok := p.markup(i, to); ok {
+			i = next
+			continue
+		}
+		if p.text[i] == '\\' && i+1 < to && isMarkdownSpecial(p.text[i+1]) {
+			i++
+		}
+		p.write(p.text[i])
+		i++
+	}
+}
+
+// markup разбирает разметку, которая начинается в i; возвращает позицию за ней
+func (p *markdownParser) markup(i, to int) (int, bool) {
+	switch {
+	case p.hasPrefix(i, to, "```"):
+		return p.code(i, to, "```", models.EntityPre)
+	case p.text[i] == '`':
+		return p.code(i, to, "`", models.EntityCode)
+	case p.text[i] == '[':
+		return p.link(i, to)
+	}
+
+	for _, style := range markdownStyles {
+		if !p.hasPrefix(i, to, style.marker) {
+			continue
+		}
+		start := i + len(style.marker)
+		if start >= to || unicode.IsSpace(p.text[start]) {
+			continue
+		}
+		end := p.closer(start, to, style.marker)
+		if end < 0 {
+			continue
+		}
+		offset := p.units
+		p.parse(start, end)
+		p.add(style.entity, offset, "")
+		return end + len(style.marker), true
+	}
+	return 0, false
+}
+
+// code выводит содержимое кода без разбора разметки
+func (p *markdownParser) code(i, to int, marker string, entity models.EntityType) (int, bool) {
+	start := i + len(marker)
+	end := p.index(start, to, marker)
+	if end <= start {
+		return 0, false
+	}
+	body := p.text[start:end]
+	if entity == models.EntityPre {
+		if len(body) > 0 && body[0] == '\n' {
+			body = body[1:]
+		}
+		if len(body) > 0 && body[len(body)-1] == '\n' {
+			body = body[:len(body)-1]
+		}
+	}
+	offset := p.units
+	for _, r := range body {
+		p.write(r)
+	}
+	p.add(entity, offset, "")
+	return end + len(marker), true
+}
+
+// link разбирает [текст](ссылка); адрес проверяется позже вместе с остальными фрагментами
+func (p *markdownParser) link(i, to int) (int, bool) {
+	labelEnd := p.index(i+1, to, "]")
+	if labelEnd <= i+1 || !p.hasPrefix(labelEnd+1, to, "(") {
+		return 0, false
+	}
+	// Скобки внутри адреса допускаются парами: [вики](https://en.wikipedia.org/wiki/Go_(language))
+	urlEnd, depth := -1, 0
+	for j := labelEnd + 2; j < to && urlEnd < 0; j++ {
+		switch p.text[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				urlEnd = j
+			}
+			depth--
+		}
+	}
+	if urlEnd < 0 {
+		return 0, false
+	}
+	offset := p.units
+	p.parse(i+1, labelEnd)
+	p.add(models.EntityTextLink, offset, string(p.text[labelEnd+2:urlEnd]))
+	return urlEnd + 1, true
+}
+
+// closer ищет закрывающий маркер: не экранированный и не после пробела.
+// Для * пропускаются **, чтобы *курсив **с жирным** внутри* закрывался правильно
+func (p *markdownParser) closer(from, to int, marker string) int {
+	for j := from; j < to; j++ {
+		if p.text[j] == '\\' {
+			j++
+			continue
+		}
+		if marker == "*" && p.hasPrefix(j, to, "**") {
+			j++
+			continue
+		}
+		if p.hasPrefix(j, to, marker) && j > from && !unicode.IsSpace(p.text[j-1]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// index ищет маркер без учета экранирования (внутри кода \ - обычный символ)
+func (p *markdownParser) index(from, to int, marker string) int {
+	for j := from; j < to; j++ {
+		if p.hasPrefix(j, to, marker) {
+			return j
+		}
+	}
+	return -1
+}
+
+func (p *markdownParser) hasPrefix(i, to int, marker string) bool {
+	if i+len(marker) > to {
+		return false
+	}
+	for k := 0; k < len(marker); k++ {
+		if p.text[i+k] != rune(marker[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *markdownParser) write(r rune) {
+	p.out = append(p.out, r)
+	p.units += utf16RuneLength(r)
+}
+
+// add добавляет фрагмент от offset до конца выведенного текста, если он не пустой
+func (p *markdownParser) add(entity models.EntityType, offset int, link string) {
+	if p.units > offset {
+		p.entities = append(p.entities, models.MessageEntity{
+			Type:   entity,
+			Offset: offset,
+			Length: p.units - offset,
+			URL:    link,
+		})
+	}
+}
+
+func isMarkdownSpecial(r rune) bool {
+	return strings.ContainsRune("\\*_~|`[]()", r)
+}
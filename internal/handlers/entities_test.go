@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"swirl-backend/internal/models"
+)
+
+// entity короткая запись фрагмента для таблиц
+func entity(entityType models.EntityType, offset, length int) models.MessageEntity {
+	return models.MessageEntity{Type: entityType, Offset: offset, Length: length}
+}
+
+func link(offset, length int, url string) models.MessageEntity {
+	return models.MessageEntity{Type: models.EntityTextLink, Offset: offset, Length: length, URL: url}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     string
+		entities models.MessageEntities
+	}{
+		{name: "bold", content: "**hi**", want: "hi",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 2)}},
+		{name: "all styles", content: "__u__ ~~s~~ ||p||", want: "u s p",
+			entities: models.MessageEntities{
+				entity(models.EntityUnderline, 0, 1),
+				entity(models.EntityStrikethrough, 2, 1),
+				entity(models.EntitySpoiler, 4, 1),
+			}},
+		{name: "italic inside bold", content: "**bold *it* x**", want: "bold it x",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 9), entity(models.EntityItalic, 5, 2)}},
+		{name: "bold inside italic", content: "*a **b** c*", want: "a b c",
+			entities: models.MessageEntities{entity(models.EntityItalic, 0, 5), entity(models.EntityBold, 2, 1)}},
+		{name: "unclosed bold", content: "**open", want: "**open"},
+		{name: "unclosed italic", content: "*a", want: "*a"},
+		{name: "unclosed code", content: "`code", want: "`code"},
+		{name: "unclosed link", content: "[x](http", want: "[x](http"},
+		{name: "unclosed inner marker", content: "**a *b**", want: "a *b",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 4)}},
+		{name: "space after marker", content: "* not*", want: "* not*"},
+		{name: "space before closer", content: "*not *", want: "*not *"},
+		{name: "lone markers", content: "a ** b", want: "a ** b"},
+		{name: "escaped markers", content: `\*x\*`, want: "*x*"},
+		{name: "no markup in code", content: "`**x**`", want: "**x**",
+			entities: models.MessageEntities{entity(models.EntityCode, 0, 5)}},
+		{name: "backslash in code", content: "`a\\b`", want: "a\\b",
+			entities: models.MessageEntities{entity(models.EntityCode, 0, 3)}},
+		{name: "pre trims newlines", content: "```\nfoo\n```", want: "foo",
+			entities: models.MessageEntities{entity(models.EntityPre, 0, 3)}},
+		{name: "link", content: "[site](https://example.com)", want: "site",
+			entities: models.MessageEntities{link(0, 4, "https://example.com")}},
+		{name: "link with parentheses", content: "[go](https://en.wikipedia.org/wiki/Go_(language))", want: "go",
+			entities: models.MessageEntities{link(0, 2, "https://en.wikipedia.org/wiki/Go_(language)")}},
+		{name: "bold link label", content: "[**x**](https://a.io)", want: "x",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 1), link(0, 1, "https://a.io")}},
+		// Смещения в единицах UTF-16: эмодзи вне BMP занимает две
+		{name: "emoji before bold", content: "😀 **hi**", want: "😀 hi",
+			entities: models.MessageEntities{entity(models.EntityBold, 3, 2)}},
+		{name: "emoji inside bold", content: "**😀👍**", want: "😀👍",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 4)}},
+		{name: "emoji with modifier", content: "👍🏽 *ok*", want: "👍🏽 ok",
+			entities: models.MessageEntities{entity(models.EntityItalic, 5, 2)}},
+		{name: "cyrillic", content: "привет **мир**", want: "привет мир",
+			entities: models.MessageEntities{entity(models.EntityBold, 7, 3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, entities := parseMarkdown(tt.content)
+			if got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+			if len(entities) == 0 && len(tt.entities) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("entities = %+v, want %+v", entities, tt.entities)
+			}
+		})
+	}
+}
+
+func TestNormalizeEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entities models.MessageEntities
+		want     models.MessageEntities
+		wantErr  bool
+	}{
+		{name: "nested", content: "abcdef",
+			entities: models.MessageEntities{entity(models.EntityItalic, 1, 2), entity(models.EntityBold, 0, 6)},
+			want:     models.MessageEntities{entity(models.EntityBold, 0, 6), entity(models.EntityItalic, 1, 2)}},
+		{name: "same range", content: "abc",
+			entities: models.MessageEntities{entity(models.EntityCode, 0, 3), entity(models.EntityBold, 0, 3)},
+			want:     models.MessageEntities{entity(models.EntityBold, 0, 3), entity(models.EntityCode, 0, 3)}},
+		{name: "adjacent", content: "abcd",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 2), entity(models.EntityItalic, 2, 2)},
+			want:     models.MessageEntities{entity(models.EntityBold, 0, 2), entity(models.EntityItalic, 2, 2)}},
+		{name: "partial overlap", content: "abcdef",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 3), entity(models.EntityItalic, 2, 3)},
+			wantErr:  true},
+		{name: "entity inside code", content: "abcdef",
+			entities: models.MessageEntities{entity(models.EntityPre, 0, 6), entity(models.EntityBold, 1, 2)},
+			wantErr:  true},
+		{name: "unknown type", content: "abc",
+			entities: models.MessageEntities{entity("blink", 0, 3)}, wantErr: true},
+		{name: "empty", content: "abc",
+			entities: models.MessageEntities{entity(models.EntityBold, 1, 0)}, wantErr: true},
+		{name: "negative offset", content: "abc",
+			entities: models.MessageEntities{entity(models.EntityBold, -1, 2)}, wantErr: true},
+		{name: "past the end", content: "abc",
+			entities: models.MessageEntities{entity(models.EntityBold, 1, 3)}, wantErr: true},
+		{name: "surrogate pair", content: "😀x",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 2), entity(models.EntityItalic, 2, 1)},
+			want:     models.MessageEntities{entity(models.EntityBold, 0, 2), entity(models.EntityItalic, 2, 1)}},
+		{name: "inside surrogate pair", content: "😀x",
+			entities: models.MessageEntities{entity(models.EntityBold, 1, 2)}, wantErr: true},
+		{name: "half of surrogate pair", content: "😀x",
+			entities: models.MessageEntities{entity(models.EntityBold, 0, 1)}, wantErr: true},
+		{name: "url found by server", content: "https://a.io",
+			entities: models.MessageEntities{{Type: models.EntityURL, Offset: 0, Length: 12}}},
+		{name: "javascript link", content: "click",
+			entities: models.MessageEntities{link(0, 5, "javascript:alert(1)")}},
+		{name: "javascript link in capitals", content: "click",
+			entities: models.MessageEntities{link(0, 5, "JavaScript:alert(1)")}},
+		{name: "data link", content: "click",
+			entities: models.MessageEntities{link(0, 5, "data:text/html;base64,PHNjcmlwdD4=")}},
+		{name: "link without host", content: "click",
+			entities: models.MessageEntities{link(0, 5, "https://")}},
+		{name: "link without scheme", content: "click",
+			entities: models.MessageEntities{link(0, 5, "example.com/path")},
+			want:     models.MessageEntities{link(0, 5, "https://example.com/path")}},
+		{name: "link scheme lowercased", content: "click",
+			entities: models.MessageEntities{link(0, 5, "HTTPS://example.com")},
+			want:     models.MessageEntities{link(0, 5, "https://example.com")}},
+		{name: "mailto", content: "mail",
+			entities: models.MessageEntities{link(0, 4, "mailto:vasya@example.com")},
+			want:     models.MessageEntities{link(0, 4, "mailto:vasya@example.com")}},
+		{name: "url dropped from other types", content: "abc",
+			entities: models.MessageEntities{{Type: models.EntityBold, Offset: 0, Length: 3, URL: "https://a.io"}},
+			want:     models.MessageEntities{entity(models.EntityBold, 0, 3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeEntities(tt.content, tt.entities)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entities = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatContentURLs(t *testing.T) {
+	url := func(offset, length int) models.MessageEntity { return entity(models.EntityURL, offset, length) }
+	tests := []struct {
+		name      string
+		content   string
+		parseMode string
+		want      models.MessageEntities
+	}{
+		{name: "plain", content: "see https://example.com", want: models.MessageEntities{url(4, 19)}},
+		{name: "trailing period", content: "see https://example.com.", want: models.MessageEntities{url(4, 19)}},
+		{name: "trailing punctuation", content: "https://a.io/?q=1!?", want: models.MessageEntities{url(0, 17)}},
+		{name: "in parentheses", content: "(https://a.io)", want: models.MessageEntities{url(1, 12)}},
+		{name: "own parentheses", content: "https://en.wikipedia.org/wiki/Go_(language)",
+			want: models.MessageEntities{url(0, 43)}},
+		{name: "www", content: "www.example.com, ok", want: models.MessageEntities{url(0, 15)}},
+		{name: "after emoji", content: "😀 https://a.io", want: models.MessageEntities{url(3, 12)}},
+		{name: "inside bold", content: "**https://a.io**", parseMode: parseModeMarkdown,
+			want: models.MessageEntities{entity(models.EntityBold, 0, 12), url(0, 12)}},
+		{name: "inside code", content: "`https://a.io`", parseMode: parseModeMarkdown,
+			want: models.MessageEntities{entity(models.EntityCode, 0, 12)}},
+		{name: "link label", content: "[https://a.io](https://b.io)", parseMode: parseModeMarkdown,
+			want: models.MessageEntities{link(0, 12, "https://b.io")}},
+		{name: "crossing bold", content: "**see https://**a.io", parseMode: parseModeMarkdown,
+			want: models.MessageEntities{entity(models.EntityBold, 0, 12)}},
+		{name: "javascript link dropped", content: "[x](javascript:alert(1))", parseMode: parseModeMarkdown},
+		{name: "data link dropped", content: "[x](data:text/html,hi)", parseMode: parseModeMarkdown},
+		{name: "javascript is not a url", content: "javascript:alert(1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := messageContent{Content: tt.content, ParseMode: tt.parseMode}
+			if err := formatContent(&m); err != nil {
+				t.Fatalf("formatContent: %v", err)
+			}
+			if len(m.Entities) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(m.Entities, tt.want) {
+				t.Errorf("entities = %+v, want %+v", m.Entities, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimURLPunctuation(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://a.io", "https://a.io"},
+		{"https://a.io.", "https://a.io"},
+		{"https://a.io/path/...", "https://a.io/path/"},
+		{"https://a.io),", "https://a.io"},
+		{"https://a.io'\"", "https://a.io"},
+		{"https://a.io]}", "https://a.io"},
+		{"https://a.io/(x)", "https://a.io/(x)"},
+		{"https://a.io/(x)).", "https://a.io/(x)"},
+		{"https://a.io/#frag;", "https://a.io/#frag"},
+	}
+	for _, tt := range tests {
+		if got := trimURLPunctuation(tt.raw); got != tt.want {
+			t.Errorf("trimURLPunctuation(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
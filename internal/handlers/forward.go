@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Ограничения одной пересылки
+const (
+	maxForwardMessages = 100
+	maxForwardChats    = 20
+)
+
+type ForwardMessagesRequest struct {
+	MessageIDs []string `json:"message_ids"` // Для POST /messages/forward
+	ChatIDs    []string `json:"chat_ids" binding:"required"`
+}
+
+// forwardFailure - чат, в который переслать не удалось
+type forwardFailure struct {
+	ChatID string `json:"chat_id"`
+	Error  string `json:"error"`
+	Status int    `json:"-"` // HTTP статус, если бы пересылали только в этот чат
+}
+
+// ForwardMessage пересылает одно сообщение в один или несколько чатов
+func (h *MessageHandler) ForwardMessage(c *gin.Context) {
+	var req ForwardMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.forwardMessages(c, []string{c.Param("id")}, req.ChatIDs)
+}
+
+// ForwardMessages пересылает несколько сообщений, сохраняя их порядок в исходном чате
+func (h *MessageHandler) ForwardMessages(c *gin.Context) {
+	var req ForwardMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.forwardMessages(c, req.MessageIDs, req.ChatIDs)
+}
+
+func (h *MessageHandler) forwardMessages(c *gin.Context, messageIDs, chatIDs []string) {
+	userID := c.GetString("user_id")
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if len(messageIDs) == 0 || len(messageIDs) > maxForwardMessages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and 100 messages can be forwarded at once"})
+		return
+	}
+	if len(chatIDs) == 0 || len(chatIDs) > maxForwardChats {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and 20 target chats are allowed"})
+		return
+	}
+	messageIDs, ok := canonicalIDs(c, messageIDs)
+	if !ok {
+		return
+	}
+	chatIDs, ok = canonicalIDs(c, chatIDs)
+	if !ok {
+		return
+	}
+
+	// Исходные сообщения должны быть из чатов пользователя, не удалены и не служебные
+	var sources []models.Message
+	if err := h.db.Preload("User").Preload("Chat").Preload("Poll.Options").
+		Where("id IN ?", messageIDs).
+		Where("chat_id IN (?)", h.db.Model(&models.ChatUser{}).Select("chat_id").Where("user_id = ?", userID)).
+		Find(&sources).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forward messages"})
+		return
+	}
+	if len(sources) != len(messageIDs) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	for _, source := range sources {
+		if source.IsDeleted() || source.Type == models.MessageTypeSystem {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Deleted and system messages cannot be forwarded", "message_id": source.ID})
+			return
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if !sources[i].CreatedAt.Equal(sources[j].CreatedAt) {
+			return sources[i].CreatedAt.Before(sources[j].CreatedAt)
+		}
+		return sources[i].ID.String() < sources[j].ID.String()
+	})
+
+	var (
+		forwarded []models.Message
+		failed    []forwardFailure
+	)
+	for _, chatID := range chatIDs {
+		var chatUser models.ChatUser
+		if err := h.db.Preload("Chat").Where("chat_id = ? AND user_id = ?", chatID, userID).First(&chatUser).Error; err != nil {
+			failed = append(failed, forwardFailure{ChatID: chatID, Error: "Access denied", Status: http.StatusForbidden})
+			continue
+		}
+		blocked, err := blockedByRecipient(h.db, chatUser.ChatID, userUUID)
+		if err != nil {
+			failed = append(failed, forwardFailure{ChatID: chatID, Error: "Failed to forward messages", Status: http.StatusInternalServerError})
+			continue
+		}
+		if blocked {
+			failed = append(failed, forwardFailure{ChatID: chatID, Error: "Recipient is blocked", Status: http.StatusForbidden})
+			continue
+		}
+
+		// Время задается явно, чтобы порядок пачки сохранился и при одинаковых отметках
+		base := time.Now().Truncate(time.Microsecond)
+		copies := make([]models.Message, 0, len(sources))
+		for i := range sources {
+			fwd := forwardCopy(&sources[i], chatUser.ChatID, userUUID)
+			fwd.CreatedAt = base.Add(time.Duration(i) * time.Microsecond)
+			fwd.ExpiresAt = models.MessageExpiry(fwd.CreatedAt, chatUser.Chat.MessageTTL, 0)
+			// Исчезающее сообщение и в копии исчезает не позже оригинала
+			if expiresAt := sources[i].ExpiresAt; expiresAt != nil && (fwd.ExpiresAt == nil || expiresAt.Before(*fwd.ExpiresAt)) {
+				fwd.ExpiresAt = expiresAt
+			}
+			copies = append(copies, fwd)
+		}
+		if err := h.db.Transaction(func(tx *gorm.DB) error {
+			return tx.Create(&copies).Error
+		}); err != nil {
+			failed = append(failed, forwardFailure{ChatID: chatID, Error: "Failed to forward messages", Status: http.StatusInternalServerError})
+			continue
+		}
+
+		for i := range copies {
+			h.publishMessage(&copies[i], &chatUser, nil)
+		}
+		forwarded = append(forwarded, copies...)
+	}
+
+	// Если не удалось ни в один чат, ответ - худший из статусов: внутренняя ошибка важнее отказа в доступе
+	status := http.StatusCreated
+	if len(forwarded) == 0 {
+		status = http.StatusForbidden
+		for _, f := range failed {
+			status = max(status, f.Status)
+		}
+	}
+	c.JSON(status, gin.H{
+		"messages": forwarded,
+		"failed":   failed,
+	})
+}
+
+// forwardCopy создает пересылаемую копию: медиа не копируется, а передается ссылкой, опрос - копируется.
+// При пересылке пересланного сохраняется изначальный источник
+func forwardCopy(source *models.Message, chatID, userID uuid.UUID) models.Message {
+	message := models.Message{
+		ChatID:      chatID,
+		UserID:      userID,
+		Type:        source.Type,
+		Content:     source.Content,
+		Entities:    source.Entities,
+		LinkPreview: source.LinkPreview,
+		MediaURL:    source.MediaURL,
+	}
+
+	// Опрос пересылается без голосов: у копии свое голосование
+	if source.Poll != nil {
+		poll := models.Poll{
+			Anonymous:      source.Poll.Anonymous,
+			MultipleChoice: source.Poll.MultipleChoice,
+			ClosesAt:       source.Poll.ClosesAt,
+		}
+		for _, option := range source.Poll.Options {
+			poll.Options = append(poll.Options, models.PollOption{ID: option.ID, Text: option.Text})
+		}
+		message.Poll = &poll
+	}
+
+	if source.ForwardedFromMessageID != nil {
+		message.ForwardedFromMessageID = source.ForwardedFromMessageID
+		message.ForwardedFromUserID = source.ForwardedFromUserID
+		message.ForwardedFromName = source.ForwardedFromName
+		message.ForwardedFromChatID = source.ForwardedFromChatID
+		message.ForwardedFromChatName = source.ForwardedFromChatName
+		return message
+	}
+
+	message.ForwardedFromMessageID = &source.ID
+	if source.User.ShowUsername {
+		message.ForwardedFromUserID = &source.UserID
+		message.ForwardedFromName = source.User.Username
+	}
+	if source.Chat.Type == models.ChatTypeGroup {
+		message.ForwardedFromChatID = &source.ChatID
+		message.ForwardedFromChatName = source.Chat.Name
+	}
+	return message
+}
+
+// canonicalIDs проверяет UUID, приводит их к каноническому виду и убирает повторы, сохраняя порядок
+func canonicalIDs(c *gin.Context, values []string) ([]string, bool) {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID: " + v})
+			return nil, false
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			result = append(result, id.String())
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type UpgradeGuestRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=20"`
+	Password string `json:"password" binding:"required,min=6"`
+	Email    string `json:"email" binding:"omitempty,email"`
+}
+
+// CreateGuest создает гостевой аккаунт для чатрулетки без регистрации
+func (h *AuthHandler) CreateGuest(c *gin.Context) {
+	// Гость входит только по токену, пароль никому не известен
+	secret, _, err := models.NewSecretToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest"})
+		return
+	}
+
+	user := models.User{
+		Username:     "guest_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12],
+		IsGuest:      true,
+		Passwordless: true,
+	}
+	if err := user.HashPassword(secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.db.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest"})
+		return
+	}
+
+	token, err := h.generateToken(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, AuthResponse{
+		Token: token,
+		User:  user.Account(),
+	})
+}
+
+// UpgradeGuest превращает гостевой аккаунт в полноценный. ID пользователя
+// не меняется, поэтому сохраненные чаты и сообщения остаются
+func (h *AuthHandler) UpgradeGuest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req UpgradeGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.IsGuest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Account is not a guest account"})
+		return
+	}
+
+	if h.usernameTaken(req.Username, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if email != "" && h.emailTaken(email, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	if err := user.HashPassword(req.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	user.Username = req.Username
+	user.IsGuest = false
+	user.Passwordless = false
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"username":     user.Username,
+		"password":     user.Password,
+		"is_guest":     false,
+		"passwordless": false,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upgrade account"})
+		return
+	}
+
+	if email != "" {
+		if err := h.sendEmailVerification(&user, email); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, user.Account())
+}
+
+// CleanupIdleGuests удаляет гостевые аккаунты без активности дольше GuestIdleTTL.
+// Чаты, созданные гостем вместе с другими участниками, передаются собеседнику.
+// Загруженные гостем файлы удаляются с диска, кроме тех, на которые еще ссылаются пересланные копии
+func (h *AuthHandler) CleanupIdleGuests() error {
+	cutoff := time.Now().Add(-h.cfg.GuestIdleTTL)
+
+	var guestIDs []uuid.UUID
+	err := h.db.Model(&models.User{}).
+		Where("is_guest = ? AND COALESCE(last_seen, updated_at) < ? AND updated_at < ?", true, cutoff, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM sessions s WHERE s.user_id = users.id AND s.created_at >= ?)", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM messages m WHERE m.user_id = users.id AND m.created_at >= ?)", cutoff).
+		Limit(500).
+		Pluck("id", &guestIDs).Error
+	if err != nil || len(guestIDs) == 0 {
+		return err
+	}
+
+	fmt.Printf("Очистка гостей: удаляем %d неактивных гостевых аккаунтов\n", len(guestIDs))
+
+	var media []string
+	if err := h.db.Model(&models.Upload{}).Where("user_id IN ?", guestIDs).Pluck("url", &media).Error; err != nil {
+		return err
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return deleteUsers(tx, guestIDs)
+	}); err != nil {
+		return err
+	}
+
+	// Файлы удаляются после коммита: если транзакция откатилась, они еще нужны
+	removeUnusedMedia(h.db, h.cfg.UploadPath, media)
+	for _, id := range guestIDs {
+		// Папка с файлами, на которые еще ссылаются, не пуста: os.Remove ее не тронет
+		os.Remove(filepath.Join(h.cfg.UploadPath, id.String()))
+	}
+	return nil
+}
+
+// deleteUsers удаляет пользователей вместе со всеми зависимыми записями
+func deleteUsers(tx *gorm.DB, userIDs []uuid.UUID) error {
+	// Чаты с другими участниками передаем первому из них
+	if err := tx.Exec(`
+		UPDATE chats SET created_by = (
+			SELECT cu.user_id FROM chat_users cu
+			WHERE cu.chat_id = chats.id AND cu.user_id NOT IN ?
+			ORDER BY cu.joined_at LIMIT 1
+		)
+		WHERE created_by IN ? AND EXISTS (
+			SELECT 1 FROM chat_users cu WHERE cu.chat_id = chats.id AND cu.user_id NOT IN ?
+		)`, userIDs, userIDs, userIDs).Error; err != nil {
+		return err
+	}
+
+	var orphanChatIDs []uuid.UUID
+	if err := tx.Model(&models.Chat{}).Where("created_by IN ?", userIDs).Pluck("id", &orphanChatIDs).Error; err != nil {
+		return err
+	}
+
+	// Ответы на удаляемые сообщения превращаем в обычные сообщения
+	deletedMessages := tx.Model(&models.Message{}).Select("id").
+		Where("user_id IN ? OR chat_id IN ?", userIDs, orphanChatIDs)
+	if err := tx.Model(&models.Message{}).Where("reply_to_id IN (?)", deletedMessages).
+		Update("reply_to_id", nil).Error; err != nil {
+		return err
+	}
+	// Ответы в тредах удаляемых сообщений тоже, а сводки оставшихся тредов пересчитываем
+	if err := tx.Model(&models.Message{}).Where("thread_root_id IN (?)", deletedMessages).
+		Update("thread_root_id", nil).Error; err != nil {
+		return err
+	}
+	var threadRoots []uuid.UUID
+	if err := tx.Model(&models.Message{}).Where("id IN (?) AND thread_root_id IS NOT NULL", deletedMessages).
+		Distinct().Pluck("thread_root_id", &threadRoots).Error; err != nil {
+		return err
+	}
+
+	steps := []struct {
+		model interface{}
+		query string
+		args  []interface{}
+	}{
+		{&models.MessageReaction{}, "user_id IN ? OR message_id IN (?)", []interface{}{userIDs, deletedMessages}},
+		{&models.MessageRevision{}, "message_id IN (?)", []interface{}{deletedMessages}},
+		{&models.MessageMention{}, "user_id IN ? OR message_id IN (?)", []interface{}{userIDs, deletedMessages}},
+		{&models.HiddenMessage{}, "user_id IN ? OR message_id IN (?)", []interface{}{userIDs, deletedMessages}},
+		{&models.PollVote{}, "user_id IN ? OR message_id IN (?)", []interface{}{userIDs, deletedMessages}},
+		{&models.PollOption{}, "message_id IN (?)", []interface{}{deletedMessages}},
+		{&models.Poll{}, "message_id IN (?)", []interface{}{deletedMessages}},
+		{&models.Message{}, "user_id IN ? OR chat_id IN ?", []interface{}{userIDs, orphanChatIDs}},
+		{&models.ScheduledMessage{}, "user_id IN ? OR chat_id IN ?", []interface{}{userIDs, orphanChatIDs}},
+		{&models.ChatUser{}, "user_id IN ? OR chat_id IN ?", []interface{}{userIDs, orphanChatIDs}},
+		{&models.ChatJoinRequest{}, "user_id IN ? OR chat_id IN ?", []interface{}{userIDs, orphanChatIDs}},
+		{&models.ChatInvite{}, "created_by IN ? OR chat_id IN ?", []interface{}{userIDs, orphanChatIDs}},
+		{&models.Chat{}, "id IN ?", []interface{}{orphanChatIDs}},
+		{&models.SearchQueue{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.UserBlock{}, "user_id IN ? OR blocked_user_id IN ?", []interface{}{userIDs, userIDs}},
+		{&models.Session{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.IdempotencyKey{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.Upload{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.PasswordResetToken{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.EmailVerificationToken{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.UserIdentity{}, "user_id IN ?", []interface{}{userIDs}},
+		{&models.OIDCLoginState{}, "link_user_id IN ?", []interface{}{userIDs}},
+		{&models.User{}, "id IN ?", []interface{}{userIDs}},
+	}
+	for _, step := range steps {
+		if err := tx.Where(step.query, step.args...).Delete(step.model).Error; err != nil {
+			return err
+		}
+	}
+	if err := recountThreads(tx, threadRoots); err != nil {
+		return err
+	}
+
+	// Новый создатель переданного чата становится владельцем, если владельца не осталось
+	return tx.Exec(`
+		UPDATE chat_users cu SET role = 'owner' FROM chats c
+		WHERE cu.chat_id = c.id AND cu.user_id = c.created_by AND cu.role <> 'owner'
+		AND NOT EXISTS (SELECT 1 FROM chat_users o WHERE o.chat_id = cu.chat_id AND o.role = 'owner')`).Error
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/preview"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxPreviewFetches     = 8                // Сколько страниц загружается одновременно; остальные ссылки остаются без превью
+	previewFetchTimeout   = 10 * time.Second // Вместе с редиректами и чтением страницы
+	linkPreviewFailureTTL = time.Hour        // Через сколько повторить загрузку страницы без карточки
+)
+
+// firstLink первая ссылка сообщения для превью: адрес из текста или ссылка text_link, только http(s)
+func firstLink(content string, entities models.MessageEntities) string {
+	var text []uint16
+	for _, entity := range entities {
+		var link string
+		switch entity.Type {
+		case models.EntityTextLink:
+			link = entity.URL
+		case models.EntityURL:
+			if text == nil {
+				text = utf16.Encode([]rune(content))
+			}
+			if entity.Offset < 0 || entity.End() > len(text) {
+				continue
+			}
+			sanitized, ok := sanitizeLink(string(utf16.Decode(text[entity.Offset:entity.End()])))
+			if !ok {
+				continue
+			}
+			link = sanitized
+		default:
+			continue
+		}
+		if strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://") {
+			return link
+		}
+	}
+	return ""
+}
+
+// requestLinkPreview запускает загрузку превью первой ссылки сообщения в фоне.
+// Когда превью готово, участники чата получают message_preview_ready
+func (h *MessageHandler) requestLinkPreview(message *models.Message) {
+	if h.previews == nil || message.IsDeleted() {
+		return
+	}
+	link := firstLink(message.Content, message.Entities)
+	if link == "" {
+		return
+	}
+
+	select {
+	case h.previewSlots <- struct{}{}:
+	default:
+		return
+	}
+	target := *message
+	go func() {
+		defer func() { <-h.previewSlots }()
+		if err := h.attachLinkPreview(&target, link); err != nil {
+			log.Printf("Failed to attach link preview to message %s: %v", target.ID, err)
+		}
+	}()
+}
+
+// attachLinkPreview берет превью из кеша или загружает страницу и сохраняет его в сообщении,
+// если текст и форматирование с тех пор не менялись
+func (h *MessageHandler) attachLinkPreview(message *models.Message, link string) error {
+	card, err := h.linkPreview(link)
+	if err != nil || card == nil {
+		return err
+	}
+
+	entities, err := message.Entities.Value()
+	if err != nil {
+		return err
+	}
+	result := h.db.Model(&models.Message{}).
+		Where("id = ? AND deleted_at IS NULL AND content = ?", message.ID, message.Content).
+		Where("entities IS NOT DISTINCT FROM CAST(? AS jsonb)", entities).
+		UpdateColumn("link_preview", card)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "message_preview_ready",
+		ChatID: message.ChatID.String(),
+		Payload: gin.H{
+			"message_id":   message.ID,
+			"chat_id":      message.ChatID,
+			"link_preview": card,
+		},
+	}
+	return nil
+}
+
+// linkPreview карточка ссылки из кеша; устаревшая или отсутствующая загружается заново.
+// Неудачная загрузка тоже кешируется, чтобы не ходить на недоступный сайт с каждым сообщением
+func (h *MessageHandler) linkPreview(link string) (*models.LinkPreview, error) {
+	var cached models.LinkPreviewCache
+	err := h.db.Where("url = ?", link).First(&cached).Error
+	switch {
+	case err == nil:
+		ttl := h.cfg.LinkPreviewTTL
+		if cached.Preview == nil {
+			ttl = min(ttl, linkPreviewFailureTTL)
+		}
+		if time.Since(cached.FetchedAt) < ttl {
+			return cached.Preview, nil
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), previewFetchTimeout)
+	defer cancel()
+	fetched, err := h.previews.Fetch(ctx, link)
+	if err != nil {
+		log.Printf("Link preview for %s is unavailable: %v", link, err)
+	}
+
+	entry := models.LinkPreviewCache{URL: link, FetchedAt: time.Now()}
+	if fetched != nil {
+		entry.Preview = &models.LinkPreview{
+			URL:         fetched.URL,
+			SiteName:    fetched.SiteName,
+			Title:       fetched.Title,
+			Description: fetched.Description,
+			ImageURL:    fetched.ImageURL,
+		}
+	}
+	if err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"preview", "fetched_at"}),
+	}).Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return entry.Preview, nil
+}
+
+// CleanupLinkPreviews удаляет из кеша превью старше ttl
+func (h *MessageHandler) CleanupLinkPreviews() error {
+	return h.db.Where("fetched_at < ?", time.Now().Add(-h.cfg.LinkPreviewTTL)).Delete(&models.LinkPreviewCache{}).Error
+}
+
+// newPreviewFetcher загрузчик превью или nil, если превью выключены
+func newPreviewFetcher(enabled bool) *preview.Fetcher {
+	if !enabled {
+		return nil
+	}
+	return preview.NewFetcher(preview.Config{})
+}
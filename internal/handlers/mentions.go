@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// hasMentions проверяет, ищутся ли в сообщениях этого типа упоминания: в тексте и подписях к медиа
+func hasMentions(t models.MessageType) bool {
+	return t != models.MessageTypeSticker && t != models.MessageTypeSystem
+}
+
+// resolveMentions находит в тексте упоминания участников чата, кроме самого отправителя.
+// Упоминания внутри кода не считаются
+func resolveMentions(db *gorm.DB, chatID, senderID uuid.UUID, content string, entities models.MessageEntities) ([]models.MessageMention, error) {
+	if !strings.ContainsRune(content, '@') {
+		return nil, nil
+	}
+
+	var members []models.User
+	if err := db.Model(&models.User{}).Select("users.id", "users.username").
+		Joins("JOIN chat_users ON chat_users.user_id = users.id").
+		Where("chat_users.chat_id = ? AND users.id <> ?", chatID, senderID).
+		Find(&members).Error; err != nil {
+		return nil, err
+	}
+	mentions := findMentions(content, members)
+	for _, entity := range entities {
+		if entity.Type.IsCode() {
+			mentions = withoutRange(mentions, entity.Offset, entity.End())
+		}
+	}
+	return mentions, nil
+}
+
+// withoutRange убирает упоминания, задевающие диапазон [from, to)
+func withoutRange(mentions []models.MessageMention, from, to int) []models.MessageMention {
+	kept := mentions[:0]
+	for _, mention := range mentions {
+		if mention.Offset+mention.Length <= from || to <= mention.Offset {
+			kept = append(kept, mention)
+		}
+	}
+	return kept
+}
+
+// findMentions ищет @username участников без учета регистра. @ должна начинать слово,
+// а имя - заканчивать его; из подходящих имен берется самое длинное (@anna_k, а не @anna)
+func findMentions(content string, members []models.User) []models.MessageMention {
+	names := make([][]rune, len(members))
+	for i := range members {
+		names[i] = []rune(members[i].Username)
+	}
+	order := make([]int, len(members))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(names[order[a]]) > len(names[order[b]]) })
+
+	text := []rune(content)
+	var mentions []models.MessageMention
+	offset := 0 // Позиция text[i] в единицах UTF-16
+	for i := 0; i < len(text); {
+		if text[i] == '@' && (i == 0 || !isWordRune(text[i-1])) {
+			if member, end := matchMention(text, i+1, names, order); member >= 0 {
+				length := utf16Length(text[i:end])
+				mentions = append(mentions, models.MessageMention{
+					Offset: offset,
+					Length: length,
+					UserID: members[member].ID,
+				})
+				offset += length
+				i = end
+				continue
+			}
+		}
+		offset += utf16RuneLength(text[i])
+		i++
+	}
+	return mentions
+}
+
+// matchMention ищет имя, которое начинается в позиции start и заканчивает слово.
+// Возвращает индекс участника и позицию за именем, или -1
+func matchMention(text []rune, start int, names [][]rune, order []int) (int, int) {
+	for _, member := range order {
+		name := names[member]
+		end := start + len(name)
+		if len(name) == 0 || end > len(text) {
+			continue
+		}
+		if end < len(text) && isWordRune(text[end]) {
+			continue
+		}
+		if strings.EqualFold(string(text[start:end]), string(name)) {
+			return member, end
+		}
+	}
+	return -1, 0
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// utf16Length длина текста в единицах UTF-16
+func utf16Length(text []rune) int {
+	n := 0
+	for _, r := range text {
+		n += utf16RuneLength(r)
+	}
+	return n
+}
+
+// utf16RuneLength символы вне BMP (эмодзи) занимают в UTF-16 две единицы
+func utf16RuneLength(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// replaceMentions заново находит упоминания в отредактированном сообщении.
+// Кто был упомянут и раньше, сохраняет отметку о прочтении; возвращаются только новые упомянутые
+func replaceMentions(tx *gorm.DB, message *models.Message) ([]string, error) {
+	var previous []models.MessageMention
+	if err := tx.Where("message_id = ?", message.ID).Find(&previous).Error; err != nil {
+		return nil, err
+	}
+	mentions, err := resolveMentions(tx, message.ChatID, message.UserID, message.Content, message.Entities)
+	if err != nil {
+		return nil, err
+	}
+
+	wasMentioned := make(map[uuid.UUID]*time.Time, len(previous))
+	for _, mention := range previous {
+		wasMentioned[mention.UserID] = mention.ReadAt
+	}
+	var added []models.MessageMention
+	for i := range mentions {
+		mentions[i].MessageID = message.ID
+		if readAt, ok := wasMentioned[mentions[i].UserID]; ok {
+			mentions[i].ReadAt = readAt
+		} else {
+			added = append(added, mentions[i])
+		}
+	}
+
+	if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageMention{}).Error; err != nil {
+		return nil, err
+	}
+	if len(mentions) > 0 {
+		if err := tx.Create(&mentions).Error; err != nil {
+			return nil, err
+		}
+	}
+	return mentionedUserIDs(added), nil
+}
+
+// mentionedUserIDs упомянутые пользователи без повторов
+func mentionedUserIDs(mentions []models.MessageMention) []string {
+	seen := make(map[uuid.UUID]bool, len(mentions))
+	var ids []string
+	for _, mention := range mentions {
+		if !seen[mention.UserID] {
+			seen[mention.UserID] = true
+			ids = append(ids, mention.UserID.String())
+		}
+	}
+	return ids
+}
+
+// notifyMentioned отправляет упомянутым событие mentioned во все их соединения.
+// Событие уходит и тем, кто выключил уведомления чата
+func (h *MessageHandler) notifyMentioned(message *models.Message, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.hub.Broadcast <- websocket.Message{
+		Type:       "mentioned",
+		ChatID:     message.ChatID.String(),
+		Recipients: userIDs,
+		AllChats:   true,
+		Payload: gin.H{
+			"chat_id": message.ChatID,
+			"message": message,
+		},
+	}
+}
+
+// ReadNextMention отдает самое раннее непрочитанное упоминание пользователя в чате
+// и отмечает его прочитанным: повторные вызовы проходят по упоминаниям по порядку
+func (h *MessageHandler) ReadNextMention(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var chatUser models.ChatUser
+	if err := h.db.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&chatUser).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	unreadMentions := h.db.Model(&models.Message{}).
+		Joins("JOIN message_mentions mm ON mm.message_id = messages.id").
+		Where("messages.chat_id = ? AND mm.user_id = ? AND mm.read_at IS NULL AND messages.status <> ?",
+			chatUser.ChatID, chatUser.UserID, models.MessageStatusDeleted).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", chatUser.UserID).
+		Session(&gorm.Session{})
+
+	var message models.Message
+	if err := unreadMentions.
+		Preload("User").Preload("ReplyTo").Preload("ReplyTo.User").Preload("Mentions").
+		Order("messages.created_at ASC, messages.id ASC").
+		First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No unread mentions"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mentions"})
+		return
+	}
+
+	if err := h.db.Model(&models.MessageMention{}).
+		Where("message_id = ? AND user_id = ? AND read_at IS NULL", message.ID, chatUser.UserID).
+		Update("read_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mentions"})
+		return
+	}
+
+	var remaining int64
+	if err := unreadMentions.Distinct("messages.id").Count(&remaining).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mentions"})
+		return
+	}
+
+	found := []models.Message{message}
+	if err := attachReactions(h.db, found, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mentions"})
+		return
+	}
+	if err := attachPolls(h.db, found, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mentions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   found[0],
+		"remaining": remaining,
+	})
+}
+
+// markMentionsRead отмечает прочитанными упоминания участника в сообщениях, которые покрывает его курсор.
+// Ответы в тредах курсор чата не покрывает: их упоминания читаются только переходом
+func markMentionsRead(db *gorm.DB, chatUser *models.ChatUser) error {
+	if chatUser.LastReadAt == nil || chatUser.LastReadMessageID == nil {
+		return nil
+	}
+	return db.Exec(`
+		UPDATE message_mentions SET read_at = now()
+		WHERE user_id = ? AND read_at IS NULL AND message_id IN (
+			SELECT id FROM messages WHERE chat_id = ? AND thread_root_id IS NULL AND (created_at, id) <= (?, ?)
+		)`, chatUser.UserID, chatUser.ChatID, *chatUser.LastReadAt, *chatUser.LastReadMessageID).Error
+}
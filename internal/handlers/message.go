@@ -1,39 +1,66 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
+	"slices"
+	"time"
 
+	"swirl-backend/internal/config"
 	"swirl-backend/internal/models"
+	"swirl-backend/internal/preview"
 	"swirl-backend/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessageHandler struct {
 	db  *gorm.DB
 	hub *websocket.Hub
+	cfg *config.Config
+
+	// Загрузка превью ссылок; nil, если превью выключены
+	previews     *preview.Fetcher
+	previewSlots chan struct{}
 }
 
-func NewMessageHandler(db *gorm.DB, hub *websocket.Hub) *MessageHandler {
-	return &MessageHandler{db: db, hub: hub}
+func NewMessageHandler(db *gorm.DB, hub *websocket.Hub, cfg *config.Config) *MessageHandler {
+	return &MessageHandler{
+		db:           db,
+		hub:          hub,
+		cfg:          cfg,
+		previews:     newPreviewFetcher(cfg.LinkPreviews),
+		previewSlots: make(chan struct{}, maxPreviewFetches),
+	}
 }
 
+var errClientMessageIDReused = errors.New("client_message_id is already used in another chat")
+
 type SendMessageRequest struct {
-	Type      string `json:"type" binding:"required"`
-	Content   string `json:"content" binding:"required"`
-	MediaURL  string `json:"media_url,omitempty"`
-	ReplyToID string `json:"reply_to_id,omitempty"`
+	Type            string                 `json:"type" binding:"required"`
+	Content         string                 `json:"content"`
+	Entities        models.MessageEntities `json:"entities,omitempty"`   // Форматирование текста, позиции в UTF-16
+	ParseMode       string                 `json:"parse_mode,omitempty"` // markdown - форматирование задано разметкой в content
+	MediaURL        string                 `json:"media_url,omitempty"`
+	StickerID       string                 `json:"sticker_id,omitempty"` // Для type=sticker
+	Poll            *PollRequest           `json:"poll,omitempty"`       // Для type=poll
+	ReplyToID       string                 `json:"reply_to_id,omitempty"`
+	ThreadRootID    string                 `json:"thread_root_id,omitempty"`                     // Ответ в тред этого сообщения
+	SendAt          *time.Time             `json:"send_at,omitempty"`                            // Отложенная отправка
+	TTL             int                    `json:"ttl,omitempty"`                                // Исчезающее сообщение: время жизни в секундах
+	ClientMessageID string                 `json:"client_message_id,omitempty" binding:"max=64"` // Повтор с тем же значением вернет уже созданное сообщение
 }
 
+// GetMessages отдает сообщения чата от новых к старым с keyset-пагинацией:
+// before/after принимают курсор из ответа или ID сообщения, around - ID сообщения
 func (h *MessageHandler) GetMessages(c *gin.Context) {
 	chatID := c.Param("id")
 	userID := c.GetString("user_id")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset := (page - 1) * limit
+	limit := pageLimit(c, 50, 100)
 
 	// Проверяем доступ к чату
 	var chatUser models.ChatUser
@@ -42,33 +69,169 @@ func (h *MessageHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
+	before, after, around := c.Query("before"), c.Query("after"), c.Query("around")
+	given := 0
+	for _, v := range []string{before, after, around} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only one of before, after and around can be used"})
+		return
+	}
+
+	var (
+		messages           []models.Message
+		hasOlder, hasNewer bool
+		err                error
+	)
+	switch {
+	case before != "":
+		var cur *pageCursor
+		if cur, err = h.resolveMessageCursor(chatID, before); err == nil {
+			if messages, hasOlder, err = h.messagesPage(chatID, userID, cur, true, false, limit); err == nil {
+				hasNewer, err = h.hasMessages(chatID, userID, cur, false, true)
+			}
+		}
+	case after != "":
+		var cur *pageCursor
+		if cur, err = h.resolveMessageCursor(chatID, after); err == nil {
+			if messages, hasNewer, err = h.messagesPage(chatID, userID, cur, false, false, limit); err == nil {
+				hasOlder, err = h.hasMessages(chatID, userID, cur, true, true)
+			}
+		}
+	case around != "":
+		if _, parseErr := uuid.Parse(around); parseErr != nil {
+			err = errInvalidCursor
+			break
+		}
+		var cur *pageCursor
+		if cur, err = h.resolveMessageCursor(chatID, around); err == nil {
+			// Само сообщение и половина лимита в каждую сторону
+			var older, newer []models.Message
+			if older, hasOlder, err = h.messagesPage(chatID, userID, cur, true, true, limit-limit/2); err == nil {
+				if newer, hasNewer, err = h.messagesPage(chatID, userID, cur, false, false, limit/2); err == nil {
+					messages = append(newer, older...)
+				}
+			}
+		}
+	default:
+		messages, hasOlder, err = h.messagesPage(chatID, userID, nil, true, false, limit)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidCursor):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		}
+		return
+	}
+
+	if err := attachReactions(h.db, messages, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	if err := attachPolls(h.db, messages, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+
+	response := gin.H{
+		"messages":  messages,
+		"limit":     limit,
+		"has_older": hasOlder,
+		"has_newer": hasNewer,
+	}
+	if len(messages) > 0 {
+		newest, oldest := messages[0], messages[len(messages)-1]
+		response["newer_cursor"] = encodeCursor(newest.CreatedAt, newest.ID)
+		response["older_cursor"] = encodeCursor(oldest.CreatedAt, oldest.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// resolveMessageCursor превращает ID сообщения чата или непрозрачный курсор в позицию
+func (h *MessageHandler) resolveMessageCursor(chatID, value string) (*pageCursor, error) {
+	if id, err := uuid.Parse(value); err == nil {
+		var message models.Message
+		if err := h.db.Select("id", "created_at").
+			Where("id = ? AND chat_id = ?", id, chatID).
+			First(&message).Error; err != nil {
+			return nil, err
+		}
+		return &pageCursor{Time: message.CreatedAt, ID: message.ID}, nil
+	}
+	return decodeCursor(value)
+}
+
+// messagesPage выбирает до limit сообщений старше (older) или новее курсора, кроме скрытых пользователем.
+// Результат всегда отсортирован от новых к старым; второй результат - есть ли еще сообщения
+func (h *MessageHandler) messagesPage(chatID, userID string, cur *pageCursor, older, inclusive bool, limit int) ([]models.Message, bool, error) {
 	var messages []models.Message
-	query := h.db.Preload("User").
+	if limit <= 0 {
+		return messages, false, nil
+	}
+
+	query := h.pageQuery(chatID, userID, cur, older, inclusive).
+		Preload("User").
 		Preload("ReplyTo").
 		Preload("ReplyTo.User").
-		Where("chat_id = ?", chatID).
-		Order("created_at DESC")
+		Preload("Mentions")
+	order := "created_at DESC, id DESC"
+	if !older {
+		order = "created_at ASC, id ASC"
+	}
+	if err := query.Order(order).Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
 
-	if err := query.Offset(offset).Limit(limit).Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
-		return
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
 	}
+	if !older {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"messages": messages,
-		"page":     page,
-		"limit":    limit,
-	})
+// hasMessages проверяет, есть ли видимые пользователю сообщения по ту сторону курсора
+func (h *MessageHandler) hasMessages(chatID, userID string, cur *pageCursor, older, inclusive bool) (bool, error) {
+	var exists bool
+	err := h.db.Raw("SELECT EXISTS (?)", h.pageQuery(chatID, userID, cur, older, inclusive).Select("1")).
+		Scan(&exists).Error
+	return exists, err
+}
+
+// pageQuery сообщения ленты чата (без ответов в тредах и скрытых пользователем) по одну сторону курсора
+func (h *MessageHandler) pageQuery(chatID, userID string, cur *pageCursor, older, inclusive bool) *gorm.DB {
+	query := h.db.Model(&models.Message{}).
+		Where("chat_id = ? AND thread_root_id IS NULL", chatID).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", userID)
+	if cur == nil {
+		return query
+	}
+
+	op := "<"
+	if !older {
+		op = ">"
+	}
+	if inclusive {
+		op += "="
+	}
+	return query.Where("(created_at, id) "+op+" (?, ?)", cur.Time, cur.ID)
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
 	chatID := c.Param("id")
 	userID := c.GetString("user_id")
-	userUUID, err := uuid.Parse(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		return
-	}
 
 	// Проверяем доступ к чату
 	var chatUser models.ChatUser
@@ -83,49 +246,289 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
+	// Проверяем содержимое по правилам типа (служебные сообщения создает только сервер)
+	stickerID, err := parseStickerID(req.StickerID)
+	content := messageContent{
+		Type:      models.MessageType(req.Type),
+		Content:   req.Content,
+		MediaURL:  req.MediaURL,
+		StickerID: stickerID,
+		Entities:  req.Entities,
+		ParseMode: req.ParseMode,
+		Poll:      req.Poll,
+	}
+	if err == nil {
+		err = validateMessage(h.db, chatUser.UserID, &content)
+	}
+	if err != nil {
+		if !respondMessageError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		}
+		return
+	}
+	req.Content, req.MediaURL = content.Content, content.MediaURL
+	req.Entities, req.ParseMode = content.Entities, ""
+
+	if !models.ValidMessageTTL(req.TTL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ttl"})
+		return
+	}
+
+	// Отложенная отправка
+	if req.SendAt != nil {
+		if req.Poll != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Polls cannot be scheduled"})
+			return
+		}
+		h.scheduleMessage(c, &chatUser, &req)
+		return
+	}
+
+	message, created, err := h.sendMessage(&chatUser, &req, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidReplyTo), errors.Is(err, errInvalidThreadRoot):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, errClientMessageIDReused):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errRecipientBlocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Recipient is blocked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		}
+		return
+	}
+
+	// Повтор с тем же client_message_id: отдаем уже созданное сообщение
+	if !created {
+		c.JSON(http.StatusOK, message)
+		return
+	}
+	c.JSON(http.StatusCreated, message)
+}
+
+// sendMessage - общий путь отправки: проверяет блокировку, ответ и тред, создает сообщение и рассылает его.
+// Если сообщение с этим id или client_message_id отправителя уже есть, повторно оно
+// не создается и не рассылается (created = false)
+func (h *MessageHandler) sendMessage(sender *models.ChatUser, req *SendMessageRequest, scheduled *models.ScheduledMessage) (*models.Message, bool, error) {
+	// Отложенное сообщение создается с ID отложенного
+	id := uuid.Nil
+	if scheduled != nil {
+		id = scheduled.ID
+	}
+
+	// Повтор запроса: сообщение уже создано
+	if existing, err := h.findSentMessage(sender, id, req.ClientMessageID); err != nil || existing != nil {
+		if err == nil && scheduled != nil {
+			err = h.resumeScheduled(scheduled, sender, existing)
+		}
+		return existing, false, err
+	}
+
+	// В личный чат нельзя писать тому, кто заблокировал отправителя
+	if blocked, err := blockedByRecipient(h.db, sender.ChatID, sender.UserID); err != nil {
+		return nil, false, err
+	} else if blocked {
+		return nil, false, errRecipientBlocked
+	}
+
+	// Ответ и тред должны ссылаться на сообщения этого же чата
+	replyTo, threadRoot, err := resolveReplyTargets(h.db, sender.ChatID, req.ReplyToID, req.ThreadRootID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// В чате с исчезающими сообщениями сообщение получает время исчезновения
+	var chatTTL int
+	if err := h.db.Model(&models.Chat{}).Where("id = ?", sender.ChatID).Pluck("message_ttl", &chatTTL).Error; err != nil {
+		return nil, false, err
+	}
+
 	// Создаем сообщение
 	message := models.Message{
-		ChatID:   uuid.MustParse(chatID),
-		UserID:   userUUID,
-		Type:     models.MessageType(req.Type),
-		Content:  req.Content,
-		MediaURL: req.MediaURL,
+		ID:        id,
+		ChatID:    sender.ChatID,
+		UserID:    sender.UserID,
+		Type:      models.MessageType(req.Type),
+		Content:   req.Content,
+		Entities:  req.Entities,
+		MediaURL:  req.MediaURL,
+		ReplyToID: replyTo,
+		ExpiresAt: models.MessageExpiry(time.Now(), chatTTL, req.TTL),
+	}
+	if threadRoot != nil {
+		message.ThreadRootID = &threadRoot.ID
+	}
+	if req.ClientMessageID != "" {
+		message.ClientMessageID = &req.ClientMessageID
+	}
+	if message.StickerID, err = parseStickerID(req.StickerID); err != nil {
+		return nil, false, err
+	}
+
+	// Упоминания участников ищутся в тексте и подписях
+	var mentions []models.MessageMention
+	if hasMentions(message.Type) {
+		if mentions, err = resolveMentions(h.db, sender.ChatID, sender.UserID, message.Content, message.Entities); err != nil {
+			return nil, false, err
+		}
 	}
 
-	// Если есть ответ на сообщение
-	if req.ReplyToID != "" {
-		replyToUUID, err := uuid.Parse(req.ReplyToID)
-		if err == nil {
-			message.ReplyToID = &replyToUUID
+	created := false
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
 		}
+		created = true
+		if req.Poll != nil {
+			if err := tx.Create(req.Poll.model(message.ID)).Error; err != nil {
+				return err
+			}
+		}
+		if len(mentions) > 0 {
+			for i := range mentions {
+				mentions[i].MessageID = message.ID
+			}
+			if err := tx.Create(&mentions).Error; err != nil {
+				return err
+			}
+		}
+		if threadRoot != nil {
+			if err := recordThreadReply(tx, threadRoot, &message); err != nil {
+				return err
+			}
+		}
+		if scheduled != nil {
+			_, err := markScheduledSent(tx, scheduled.ID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
 
-	if err := h.db.Create(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
-		return
+	// Параллельный повтор успел создать сообщение раньше
+	if !created {
+		existing, err := h.findSentMessage(sender, id, req.ClientMessageID)
+		if err == nil && existing == nil {
+			err = errors.New("message conflicts with an existing one")
+		}
+		if err == nil && scheduled != nil {
+			err = h.resumeScheduled(scheduled, sender, existing)
+		}
+		return existing, false, err
+	}
+
+	h.publishMessage(&message, sender, threadRoot)
+	return &message, true, nil
+}
+
+// findSentMessage ищет уже созданное сообщение отправителя по ID или client_message_id.
+// Тот же client_message_id в другом чате - ошибка клиента, а не повтор
+func (h *MessageHandler) findSentMessage(sender *models.ChatUser, id uuid.UUID, clientMessageID string) (*models.Message, error) {
+	if id == uuid.Nil && clientMessageID == "" {
+		return nil, nil
+	}
+
+	query := h.db.Preload("User").Preload("ReplyTo").Preload("ReplyTo.User").Preload("Mentions").Where("user_id = ?", sender.UserID)
+	switch {
+	case id != uuid.Nil && clientMessageID != "":
+		query = query.Where("id = ? OR client_message_id = ?", id, clientMessageID)
+	case id != uuid.Nil:
+		query = query.Where("id = ?", id)
+	default:
+		query = query.Where("client_message_id = ?", clientMessageID)
 	}
 
+	var message models.Message
+	if err := query.First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if message.ChatID != sender.ChatID {
+		return nil, errClientMessageIDReused
+	}
+	found := []models.Message{message}
+	if err := attachReactions(h.db, found, sender.UserID.String()); err != nil {
+		return nil, err
+	}
+	if err := attachPolls(h.db, found, sender.UserID.String()); err != nil {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+// publishMessage доводит созданное сообщение до получателей: отмечает доставленным,
+// загружает связи, рассылает new_message (и события треда) и сдвигает курсор прочтения автора
+func (h *MessageHandler) publishMessage(message *models.Message, sender *models.ChatUser, threadRoot *models.Message) {
 	// Отмечаем как доставленное
 	message.MarkAsDelivered()
-	h.db.Save(&message)
+	h.db.Model(message).Update("status", message.Status)
 
 	// Загружаем связанные данные
-	h.db.Preload("User").Preload("ReplyTo").Preload("ReplyTo.User").First(&message, message.ID)
+	h.db.Preload("User").Preload("ReplyTo").Preload("ReplyTo.User").Preload("Mentions").First(message, "id = ?", message.ID)
+	message.Reactions = []models.ReactionCount{}
+	published := []models.Message{*message}
+	if err := attachPolls(h.db, published, sender.UserID.String()); err != nil {
+		log.Printf("Failed to load poll: %v", err)
+	}
+	message.Poll = published[0].Poll
 
 	// Отправляем сообщение через WebSocket
 	websocketMessage := websocket.Message{
 		Type:    "new_message",
-		ChatID:  chatID,
+		ChatID:  message.ChatID.String(),
 		Payload: message,
 	}
 	h.hub.Broadcast <- websocketMessage
+	if threadRoot != nil {
+		h.notifyThread(threadRoot, message)
+	}
+	h.notifyMentioned(message, mentionedUserIDs(message.Mentions))
+	if message.LinkPreview == nil {
+		h.requestLinkPreview(message)
+	}
 
-	c.JSON(http.StatusCreated, message)
+	// Свое сообщение автор прочитал. Курсор относится к ленте чата, ответ в треде его не двигает.
+	// Событие нужно, только если курсор перескочил через непрочитанные сообщения собеседников
+	if threadRoot != nil {
+		return
+	}
+	unread, err := unreadCount(h.db, sender)
+	if err != nil {
+		log.Printf("Failed to count unread messages: %v", err)
+	}
+	moved, err := moveReadCursor(h.db, sender, message.ID)
+	if err != nil {
+		log.Printf("Failed to advance read cursor: %v", err)
+	} else if moved && unread > 0 {
+		h.notifyRead(sender)
+	}
 }
 
+// DeleteMessage удаляет сообщение: scope=me скрывает его только у себя,
+// scope=everyone (по умолчанию) оставляет у всех "надгробие" без содержимого
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 	messageID := c.Param("id")
 	userID := c.GetString("user_id")
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	scope := c.DefaultQuery("scope", "everyone")
+	if scope != "me" && scope != "everyone" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be me or everyone"})
+		return
+	}
 
 	// Находим сообщение
 	var message models.Message
@@ -134,37 +537,87 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, является ли пользователь автором сообщения
-	if message.UserID.String() != userID {
+	// Проверяем, что пользователь является участником чата
+	var chatUser models.ChatUser
+	if err := h.db.Preload("Chat").Where("chat_id = ? AND user_id = ?", message.ChatID, userID).First(&chatUser).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if scope == "me" {
+		hidden := models.HiddenMessage{MessageID: message.ID, UserID: userUUID}
+		if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&hidden).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted for you", "message_id": message.ID, "scope": scope})
+		return
+	}
+
+	// У всех удаляет автор (в пределах MESSAGE_DELETE_WINDOW), а в группах еще владелец и админы
+	isAuthor := message.UserID == userUUID && message.Type != models.MessageTypeSystem
+	canModerate := chatUser.Chat.Type == models.ChatTypeGroup && chatUser.Role.Can(models.ChatActionDeleteMessages)
+	switch {
+	case !isAuthor && !canModerate:
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
+	case !canModerate && h.cfg.MessageDeleteWindow > 0 && time.Since(message.CreatedAt) > h.cfg.MessageDeleteWindow:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Delete time limit has expired"})
+		return
 	}
 
-	if err := h.db.Delete(&message).Error; err != nil {
+	if message.IsDeleted() {
+		c.JSON(http.StatusOK, message)
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return tombstoneMessage(tx, &message, userUUID)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
 		return
 	}
 
-	// Уведомляем через WebSocket
-	websocketMessage := websocket.Message{
-		Type:    "message_deleted",
-		ChatID:  message.ChatID.String(),
-		Payload: gin.H{"message_id": messageID},
+	broadcastMessageDeleted(h.hub, &message)
+	c.JSON(http.StatusOK, message)
+}
+
+// tombstoneMessage удаляет сообщение у всех: стирает содержимое, реакции и историю правок.
+// Строка остается, поэтому ответы на сообщение не ломаются
+func tombstoneMessage(tx *gorm.DB, message *models.Message, by uuid.UUID) error {
+	if err := deleteMessageDependents(tx, []uuid.UUID{message.ID}); err != nil {
+		return err
 	}
-	h.hub.Broadcast <- websocketMessage
+	message.MarkAsDeleted(by)
+	return saveTombstone(tx, message)
+}
+
+// saveTombstone сохраняет стертое содержимое и отметку об удалении
+func saveTombstone(tx *gorm.DB, message *models.Message) error {
+	return tx.Model(message).Select("status", "content", "entities", "link_preview", "media_url", "sticker_id",
+		"forwarded_from_message_id", "forwarded_from_user_id", "forwarded_from_name",
+		"forwarded_from_chat_id", "forwarded_from_chat_name", "deleted_at", "deleted_by").
+		Updates(message).Error
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+// broadcastMessageDeleted уведомляет участников чата об удалении сообщения у всех
+func broadcastMessageDeleted(hub *websocket.Hub, message *models.Message) {
+	hub.Broadcast <- websocket.Message{
+		Type:   "message_deleted",
+		ChatID: message.ChatID.String(),
+		Payload: gin.H{
+			"message_id": message.ID,
+			"chat_id":    message.ChatID,
+			"deleted_by": message.DeletedBy,
+			"deleted_at": message.DeletedAt,
+		},
+	}
 }
 
-// MarkMessageAsRead отмечает сообщение как прочитанное
+// MarkMessageAsRead отмечает прочитанными все сообщения чата до указанного включительно
 func (h *MessageHandler) MarkMessageAsRead(c *gin.Context) {
 	messageID := c.Param("id")
 	userID := c.GetString("user_id")
-	userUUID, err := uuid.Parse(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		return
-	}
 
 	// Находим сообщение
 	var message models.Message
@@ -180,37 +633,37 @@ func (h *MessageHandler) MarkMessageAsRead(c *gin.Context) {
 		return
 	}
 
-	// Отмечаем как прочитанное
-	message.MarkAsRead(userUUID)
-	
-	if err := h.db.Save(&message).Error; err != nil {
+	if err := h.advanceReadCursor(&chatUser, message.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message status"})
 		return
 	}
 
-	// Отправляем обновление через WebSocket
-	websocketMessage := websocket.Message{
-		Type:    "message_status_update",
-		ChatID:  message.ChatID.String(),
-		Payload: gin.H{
-			"message_id": message.ID,
-			"status":     message.Status,
-			"read_by":    message.ReadBy,
-			"read_at":    message.ReadAt,
-		},
+	status, err := readStatus(h.db, &message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message status"})
+		return
 	}
-	h.hub.Broadcast <- websocketMessage
 
 	c.JSON(http.StatusOK, gin.H{
-		"message_id": message.ID,
-		"status":     message.Status,
-		"status_text": message.GetStatusText(),
-		"read_by":    message.ReadBy,
-		"read_at":    message.ReadAt,
+		"message_id":           message.ID,
+		"status":               status.Status,
+		"read_by":              status.ReadBy,
+		"read_count":           status.ReadCount,
+		"recipients_count":     status.RecipientsCount,
+		"last_read_message_id": chatUser.LastReadMessageID,
+		"last_read_at":         chatUser.LastReadAt,
 	})
 }
 
-// EditMessage редактирует сообщение
+type EditMessageRequest struct {
+	Content   *string                 `json:"content,omitempty"`    // Новый текст или подпись
+	Entities  *models.MessageEntities `json:"entities,omitempty"`   // Новое форматирование; без content - для прежнего текста
+	ParseMode string                  `json:"parse_mode,omitempty"` // markdown - разметка в новом content
+	Type      string                  `json:"type,omitempty"`       // Если указан, должен совпадать с типом сообщения
+	MediaURL  string                  `json:"media_url,omitempty"`  // Замена файла, только для фото и видео
+}
+
+// EditMessage редактирует сообщение, сохраняя прежнюю версию в истории
 func (h *MessageHandler) EditMessage(c *gin.Context) {
 	messageID := c.Param("id")
 	userID := c.GetString("user_id")
@@ -228,24 +681,118 @@ func (h *MessageHandler) EditMessage(c *gin.Context) {
 		return
 	}
 
-	var editData struct {
-		Content string `json:"content" binding:"required"`
+	if message.IsDeleted() {
+		c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+		return
+	}
+	// Вопрос и варианты опроса фиксируются при отправке: после правки отданные голоса потеряли бы смысл
+	if message.Type == models.MessageTypePoll {
+		respondMessageError(c, &messageError{Code: codePollNotEditable, Field: "type", Message: "Polls cannot be edited"})
+		return
+	}
+	if !message.Type.IsEditable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Messages of this type cannot be edited"})
+		return
+	}
+	if h.cfg.MessageEditWindow > 0 && time.Since(message.CreatedAt) > h.cfg.MessageEditWindow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Edit time limit has expired"})
+		return
 	}
 
+	var editData EditMessageRequest
 	if err := c.ShouldBindJSON(&editData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Обновляем сообщение
-	message.Content = editData.Content
-	message.MarkAsEdited()
+	// Тип сообщения не меняется: текст нельзя превратить в медиа и наоборот
+	if editData.Type != "" && models.MessageType(editData.Type) != message.Type {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message type cannot be changed"})
+		return
+	}
+	if editData.Content == nil && editData.Entities == nil && editData.MediaURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	var (
+		newlyMentioned []string
+		linkChanged    bool
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Блокируем строку, чтобы параллельные правки не потеряли версии
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "id = ?", message.ID).Error; err != nil {
+			return err
+		}
+
+		// Новое содержимое проверяется по правилам типа; прежний файл считается проверенным
+		// Новый текст приходит со своим форматированием, без него - без форматирования
+		edited := messageContent{
+			Type:         message.Type,
+			Content:      message.Content,
+			Entities:     message.Entities,
+			MediaURL:     message.MediaURL,
+			KeptMediaURL: message.MediaURL,
+		}
+		if editData.Content != nil {
+			edited.Content = *editData.Content
+			edited.Entities = nil
+			edited.ParseMode = editData.ParseMode
+		}
+		if editData.Entities != nil {
+			edited.Entities = *editData.Entities
+		}
+		if editData.MediaURL != "" {
+			edited.MediaURL = editData.MediaURL
+		}
+		if err := validateMessage(tx, message.UserID, &edited); err != nil {
+			return err
+		}
+		if edited.Content == message.Content && edited.MediaURL == message.MediaURL &&
+			slices.Equal(edited.Entities, message.Entities) {
+			return nil
+		}
+
+		revision := models.MessageRevision{
+			MessageID: message.ID,
+			Content:   message.Content,
+			Entities:  message.Entities,
+			MediaURL:  message.MediaURL,
+			EditedBy:  message.UserID,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		// Превью остается, пока первая ссылка та же
+		link := firstLink(edited.Content, edited.Entities)
+		linkChanged = link != firstLink(message.Content, message.Entities) || link != "" && message.LinkPreview == nil
+		if linkChanged {
+			message.LinkPreview = nil
+		}
+
+		message.Content = edited.Content
+		message.Entities = edited.Entities
+		message.MediaURL = edited.MediaURL
+		message.MarkAsEdited()
+		if err := tx.Save(&message).Error; err != nil {
+			return err
+		}
 
-	if err := h.db.Save(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		// Упоминания ищутся в новом тексте заново; уже прочитанные остаются прочитанными
+		var err error
+		newlyMentioned, err = replaceMentions(tx, &message)
+		return err
+	})
+	if err != nil {
+		if !respondMessageError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		}
 		return
 	}
 
+	h.db.Where("message_id = ?", message.ID).Order("\"offset\"").Find(&message.Mentions)
+
 	// Отправляем обновление через WebSocket
 	websocketMessage := websocket.Message{
 		Type:    "message_edited",
@@ -253,16 +800,19 @@ func (h *MessageHandler) EditMessage(c *gin.Context) {
 		Payload: message,
 	}
 	h.hub.Broadcast <- websocketMessage
+	h.notifyMentioned(&message, newlyMentioned)
+	if linkChanged {
+		h.requestLinkPreview(&message)
+	}
 
 	c.JSON(http.StatusOK, message)
 }
 
-// GetMessageStatus возвращает статус сообщения
-func (h *MessageHandler) GetMessageStatus(c *gin.Context) {
+// GetMessageHistory возвращает прежние версии сообщения (от старых к новым)
+func (h *MessageHandler) GetMessageHistory(c *gin.Context) {
 	messageID := c.Param("id")
 	userID := c.GetString("user_id")
 
-	// Находим сообщение
 	var message models.Message
 	if err := h.db.Where("id = ?", messageID).First(&message).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
@@ -276,86 +826,42 @@ func (h *MessageHandler) GetMessageStatus(c *gin.Context) {
 		return
 	}
 
+	var revisions []models.MessageRevision
+	if err := h.db.Where("message_id = ?", message.ID).Order("created_at ASC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message history"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message_id": message.ID,
-		"status":     message.Status,
-		"status_text": message.GetStatusText(),
-		"is_edited":  message.IsEdited,
+		"content":    message.Content,
+		"entities":   message.Entities,
+		"media_url":  message.MediaURL,
 		"edited_at":  message.EditedAt,
-		"read_at":    message.ReadAt,
-		"read_by":    message.ReadBy,
-		"created_at": message.CreatedAt,
-		"updated_at": message.UpdatedAt,
+		"revisions":  revisions,
 	})
 }
 
-// LikeMessage лайкает сообщение
-func (h *MessageHandler) LikeMessage(c *gin.Context) {
-	messageID := c.Param("id")
-	userID := c.GetString("user_id")
-	userUUID, err := uuid.Parse(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		return
-	}
-
-	// Находим сообщение
-	var message models.Message
-	if err := h.db.Where("id = ?", messageID).First(&message).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
-		return
-	}
-
-	// Проверяем, что пользователь является участником чата
-	var chatUser models.ChatUser
-	if err := h.db.Where("chat_id = ? AND user_id = ?", message.ChatID, userID).First(&chatUser).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
-	// Добавляем лайк
-	if !message.LikeMessage(userUUID) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Message already liked by this user"})
-		return
+// deleteMessageDependents удаляет реакции, упоминания, опросы с голосами и историю правок сообщений (ID или подзапрос)
+func deleteMessageDependents(tx *gorm.DB, messageIDs interface{}) error {
+	if err := tx.Where("message_id IN (?)", messageIDs).Delete(&models.MessageReaction{}).Error; err != nil {
+		return err
 	}
-
-	// Сохраняем изменения
-	if err := h.db.Save(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like message"})
-		return
+	if err := tx.Where("message_id IN (?)", messageIDs).Delete(&models.MessageMention{}).Error; err != nil {
+		return err
 	}
-
-	// Отправляем обновление через WebSocket
-	websocketMessage := websocket.Message{
-		Type:    "message_liked",
-		ChatID:  message.ChatID.String(),
-		Payload: gin.H{
-			"message_id": message.ID,
-			"likes_count": message.LikesCount,
-			"liked_by":    message.LikedBy,
-			"liked_at":    message.LikedAt,
-		},
+	for _, model := range []interface{}{&models.PollVote{}, &models.PollOption{}, &models.Poll{}} {
+		if err := tx.Where("message_id IN (?)", messageIDs).Delete(model).Error; err != nil {
+			return err
+		}
 	}
-	h.hub.Broadcast <- websocketMessage
-
-	c.JSON(http.StatusOK, gin.H{
-		"message_id": message.ID,
-		"likes_count": message.LikesCount,
-		"liked_by":    message.LikedBy,
-		"liked_at":    message.LikedAt,
-		"is_liked":    true,
-	})
+	return tx.Where("message_id IN (?)", messageIDs).Delete(&models.MessageRevision{}).Error
 }
 
-// UnlikeMessage убирает лайк с сообщения
-func (h *MessageHandler) UnlikeMessage(c *gin.Context) {
+// GetMessageStatus возвращает статус сообщения
+func (h *MessageHandler) GetMessageStatus(c *gin.Context) {
 	messageID := c.Param("id")
 	userID := c.GetString("user_id")
-	userUUID, err := uuid.Parse(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		return
-	}
 
 	// Находим сообщение
 	var message models.Message
@@ -364,69 +870,30 @@ func (h *MessageHandler) UnlikeMessage(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, что пользователь является участником чата
+	// Проверяем доступ к чату
 	var chatUser models.ChatUser
 	if err := h.db.Where("chat_id = ? AND user_id = ?", message.ChatID, userID).First(&chatUser).Error; err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
 
-	// Убираем лайк
-	if !message.UnlikeMessage(userUUID) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Message not liked by this user"})
-		return
-	}
-
-	// Сохраняем изменения
-	if err := h.db.Save(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike message"})
+	status, err := readStatus(h.db, &message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message status"})
 		return
 	}
-
-	// Отправляем обновление через WebSocket
-	websocketMessage := websocket.Message{
-		Type:    "message_unliked",
-		ChatID:  message.ChatID.String(),
-		Payload: gin.H{
-			"message_id": message.ID,
-			"likes_count": message.LikesCount,
-			"liked_by":    message.LikedBy,
-			"liked_at":    message.LikedAt,
-		},
-	}
-	h.hub.Broadcast <- websocketMessage
+	message.Status = status.Status
 
 	c.JSON(http.StatusOK, gin.H{
 		"message_id": message.ID,
-		"likes_count": message.LikesCount,
-		"liked_by":    message.LikedBy,
-		"liked_at":    message.LikedAt,
-		"is_liked":    false,
+		"status":     message.Status,
+		"status_text": message.GetStatusText(),
+		"is_edited":  message.IsEdited,
+		"edited_at":  message.EditedAt,
+		"read_by":    status.ReadBy,
+		"read_count": status.ReadCount,
+		"recipients_count": status.RecipientsCount,
+		"created_at": message.CreatedAt,
+		"updated_at": message.UpdatedAt,
 	})
 }
-
-// GetMessageLikes возвращает информацию о лайках сообщения
-func (h *MessageHandler) GetMessageLikes(c *gin.Context) {
-	messageID := c.Param("id")
-	userID := c.GetString("user_id")
-
-	// Находим сообщение
-	var message models.Message
-	if err := h.db.Where("id = ?", messageID).First(&message).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
-		return
-	}
-
-	// Проверяем доступ к чату
-	var chatUser models.ChatUser
-	if err := h.db.Where("chat_id = ? AND user_id = ?", message.ChatID, userID).First(&chatUser).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
-	userUUID, _ := uuid.Parse(userID)
-	likesInfo := message.GetLikesInfo()
-	likesInfo["is_liked_by_user"] = message.IsLikedBy(userUUID)
-
-	c.JSON(http.StatusOK, likesInfo)
-}
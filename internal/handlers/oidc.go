@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// oidcStateTTL сколько живет начатый вход через провайдера
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie cookie с state начатого входа: callback принимается только в том браузере,
+// который начал вход, иначе можно было бы завершить свой вход в чужом браузере (login CSRF)
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/v1/auth/oidc"
+)
+
+var (
+	errIdentityTaken  = errors.New("identity is linked to another user")
+	errAccountBlocked = errors.New("account is blocked")
+)
+
+type OIDCHandler struct {
+	db        *gorm.DB
+	providers *oidc.Registry
+	auth      *AuthHandler
+}
+
+func NewOIDCHandler(db *gorm.DB, providers *oidc.Registry, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{db: db, providers: providers, auth: auth}
+}
+
+// GetProviders возвращает список доступных провайдеров входа
+func (h *OIDCHandler) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.providers.Names()})
+}
+
+// StartLogin начинает вход через провайдера и возвращает ссылку на авторизацию
+func (h *OIDCHandler) StartLogin(c *gin.Context) {
+	h.start(c, nil)
+}
+
+// StartLink начинает привязку внешнего аккаунта к текущему пользователю
+func (h *OIDCHandler) StartLink(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	h.start(c, &userUUID)
+}
+
+func (h *OIDCHandler) start(c *gin.Context, linkUserID *uuid.UUID) {
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+		return
+	}
+
+	state, err1 := oidc.RandomString()
+	nonce, err2 := oidc.RandomString()
+	verifier, err3 := oidc.RandomString()
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Provider unavailable"})
+		return
+	}
+
+	// Заодно удаляем просроченные состояния
+	h.db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{})
+
+	loginState := models.OIDCLoginState{
+		StateHash:    models.HashSecretToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := h.db.Create(&loginState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save state"})
+		return
+	}
+
+	// Lax, а не Strict: провайдер возвращает браузер на callback переходом с другого сайта
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), oidcStateCookiePath, "", isSecureRequest(c), true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"provider":          provider.Name(),
+		"authorization_url": authURL,
+		"expires_at":        loginState.ExpiresAt,
+	})
+}
+
+// Callback завершает вход: меняет код на токены, находит или создает пользователя
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider returned error: " + providerErr})
+		return
+	}
+
+	code := c.Query("code")
+	state := c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and state parameters required"})
+		return
+	}
+
+	// state должен совпасть с cookie браузера, который начал вход
+	boundState, err := c.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(boundState), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStateCookiePath, "", isSecureRequest(c), true)
+
+	// Состояние одноразовое: удаляем его сразу, чтобы исключить повторное использование
+	var loginState models.OIDCLoginState
+	if err := h.db.Where("state_hash = ? AND provider = ?", models.HashSecretToken(state), provider.Name()).
+		First(&loginState).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+	result := h.db.Delete(&loginState)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(loginState.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+
+	claims, err := provider.Exchange(code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("OIDC exchange with %s failed: %v", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to authenticate with provider"})
+		return
+	}
+
+	// Привязка к уже вошедшему пользователю
+	if loginState.LinkUserID != nil {
+		identity, err := h.linkIdentity(*loginState.LinkUserID, provider.Name(), claims)
+		if errors.Is(err, errIdentityTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This account is already linked to another user"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Account linked successfully",
+			"identity": identity,
+		})
+		return
+	}
+
+	user, created, err := h.findOrProvisionUser(provider.Name(), claims)
+	if errors.Is(err, errAccountBlocked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is blocked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	token, err := h.auth.generateToken(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, AuthResponse{
+		Token: token,
+		User:  user.Account(),
+	})
+}
+
+// isSecureRequest запрос пришел по HTTPS (напрямую или через прокси)
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// GetIdentities возвращает внешние аккаунты, привязанные к пользователю
+func (h *OIDCHandler) GetIdentities(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var identities []models.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity отвязывает внешний аккаунт. Последний способ входа отвязать нельзя
+func (h *OIDCHandler) UnlinkIdentity(c *gin.Context) {
+	userID := c.GetString("user_id")
+	providerName := c.Param("provider")
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var identity models.UserIdentity
+	if err := h.db.Where("user_id = ? AND provider = ?", userID, providerName).First(&identity).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	if user.Passwordless && count <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set a password before unlinking the last sign-in method"})
+		return
+	}
+
+	if err := h.db.Delete(&identity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
+
+// linkIdentity привязывает внешний аккаунт к пользователю
+func (h *OIDCHandler) linkIdentity(userID uuid.UUID, provider string, claims *oidc.Claims) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := h.db.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, errIdentityTaken
+		}
+		return &identity, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	identity = models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := h.db.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// findOrProvisionUser находит пользователя по привязке или подтвержденному email,
+// иначе создает нового пользователя без пароля со сгенерированным именем.
+// Заблокированный пользователь не входит, и к нему ничего не привязывается
+func (h *OIDCHandler) findOrProvisionUser(provider string, claims *oidc.Claims) (*models.User, bool, error) {
+	var identity models.UserIdentity
+	if err := h.db.Preload("User").Where("provider = ? AND subject = ?", provider, claims.Subject).
+		First(&identity).Error; err == nil {
+		if identity.User.IsBlocked() {
+			return nil, false, errAccountBlocked
+		}
+		return &identity.User, false, nil
+	}
+
+	// Привязываем к существующему аккаунту, только если обе стороны подтвердили адрес
+	if claims.EmailVerified && claims.Email != "" {
+		var user models.User
+		if err := h.db.Where("LOWER(email) = LOWER(?) AND email_verified = ?", claims.Email, true).
+			First(&user).Error; err == nil {
+			if user.IsBlocked() {
+				return nil, false, errAccountBlocked
+			}
+			if _, err := h.linkIdentity(user.ID, provider, claims); err != nil {
+				return nil, false, err
+			}
+			return &user, false, nil
+		}
+	}
+
+	// Случайный пароль, который никто не знает: вход только через провайдера
+	secret, _, err := models.NewSecretToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var user models.User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		username, err := h.generateUsername(tx, claims)
+		if err != nil {
+			return err
+		}
+
+		user = models.User{
+			Username:     username,
+			ProfilePhoto: claims.Picture,
+			Passwordless: true,
+		}
+		if claims.EmailVerified && claims.Email != "" {
+			email := normalizeEmail(claims.Email)
+			user.Email = &email
+			user.EmailVerified = true
+		}
+		if err := user.HashPassword(secret); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &user, true, nil
+}
+
+// generateUsername подбирает свободное имя пользователя на основе данных провайдера
+func (h *OIDCHandler) generateUsername(tx *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := sanitizeUsername(claims.PreferredUsername)
+	if base == "" && claims.Email != "" {
+		base = sanitizeUsername(strings.SplitN(claims.Email, "@", 2)[0])
+	}
+	if base == "" {
+		base = sanitizeUsername(claims.Name)
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("LOWER(username) = LOWER(?)", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+
+		suffix := fmt.Sprintf("_%04d", rand.Intn(10000))
+		if len(base)+len(suffix) > 20 {
+			candidate = base[:20-len(suffix)] + suffix
+		} else {
+			candidate = base + suffix
+		}
+	}
+
+	return "user_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12], nil
+}
+
+// sanitizeUsername оставляет только латиницу, цифры и подчеркивания (не длиннее 20 символов)
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		}
+		if b.Len() == 20 {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"swirl-backend/internal/config"
+	"swirl-backend/internal/database"
+	"swirl-backend/internal/mailer"
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/oidc"
+	"swirl-backend/internal/oidc/oidctest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// testDB база для тестов обработчиков; без TEST_DATABASE_URL тест пропускается
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.Connect(databaseURL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// oidcTestServer маршруты входа через провайдера mock; привязка выполняется от имени linkUserID
+type oidcTestServer struct {
+	router     *gin.Engine
+	issuer     *oidctest.Issuer
+	key        *rsa.PrivateKey
+	linkUserID string
+}
+
+func newOIDCTestServer(t *testing.T, db *gorm.DB) *oidcTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s := &oidcTestServer{router: gin.New(), issuer: oidctest.NewIssuer(t)}
+	s.key = s.issuer.Rotate("k1")
+
+	providers := oidc.NewRegistry([]oidc.ProviderConfig{{
+		Name:        "mock",
+		Issuer:      s.issuer.URL(),
+		ClientID:    oidctest.ClientID,
+		RedirectURL: "http://localhost:8080/api/v1/auth/oidc/mock/callback",
+	}})
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	h := NewOIDCHandler(db, providers, NewAuthHandler(db, cfg, mailer.NewLogMailer()))
+
+	api := s.router.Group("/api/v1")
+	api.GET("/auth/oidc/:provider/start", h.StartLogin)
+	api.GET("/auth/oidc/:provider/callback", h.Callback)
+	api.POST("/profile/identities/:provider", func(c *gin.Context) {
+		c.Set("user_id", s.linkUserID)
+	}, h.StartLink)
+	return s
+}
+
+func (s *oidcTestServer) do(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// begin начинает вход (или привязку) и возвращает ссылку на провайдера и cookie со state
+func (s *oidcTestServer) begin(t *testing.T, method, path string) (*url.URL, *http.Cookie) {
+	t.Helper()
+	w := s.do(httptest.NewRequest(method, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s = %d: %s", method, path, w.Code, w.Body)
+	}
+	var body struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	authURL, err := url.Parse(body.AuthorizationURL)
+	if err != nil {
+		t.Fatalf("authorization_url: %v", err)
+	}
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return authURL, cookie
+		}
+	}
+	t.Fatalf("%s %s did not set %s cookie", method, path, oidcStateCookie)
+	return nil, nil
+}
+
+// callback возвращается с провайдера: код выдан для subject с подтвержденным email subject@example.com, cookie передается, если не nil
+func (s *oidcTestServer) callback(t *testing.T, authURL *url.URL, cookie *http.Cookie, subject string) *httptest.ResponseRecorder {
+	t.Helper()
+	claims := s.issuer.Claims(subject, authURL.Query().Get("nonce"))
+	claims["email"] = subject + "@example.com"
+	claims["email_verified"] = true
+	code := s.issuer.Authorize(authURL.String(), s.issuer.Sign(s.key, "k1", claims))
+
+	query := url.Values{"code": {code}, "state": {authURL.Query().Get("state")}}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/mock/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return s.do(req)
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	s := newOIDCTestServer(t, testDB(t))
+	authURL, cookie := s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != oidcStateCookiePath {
+		t.Errorf("cookie = %+v, want HttpOnly SameSite=Lax Path=%s", cookie, oidcStateCookiePath)
+	}
+	if cookie.Value != authURL.Query().Get("state") {
+		t.Errorf("cookie does not carry the state of the authorization url")
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	// До базы запрос не доходит: state проверяется по cookie первым делом
+	s := newOIDCTestServer(t, nil)
+	authURL, _ := url.Parse(s.issuer.URL() + "/authorize?state=state-1&nonce=nonce-1")
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{name: "no cookie"},
+		{name: "another state", cookie: &http.Cookie{Name: oidcStateCookie, Value: "state-2"}},
+		{name: "empty cookie", cookie: &http.Cookie{Name: oidcStateCookie, Value: ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.callback(t, authURL, tt.cookie, "user-1"); w.Code != http.StatusBadRequest {
+				t.Fatalf("callback = %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginCallback(t *testing.T) {
+	s := newOIDCTestServer(t, testDB(t))
+	subject := "login-" + uuid.NewString()
+
+	authURL, cookie := s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	w := s.callback(t, authURL, cookie, subject)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first login = %d, want 201: %s", w.Code, w.Body)
+	}
+	var first AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &first)
+	if first.Token == "" {
+		t.Error("first login returned no token")
+	}
+
+	// state одноразовый: повтор того же callback отклоняется
+	if w := s.callback(t, authURL, cookie, subject); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback = %d, want 400", w.Code)
+	}
+
+	// Повторный вход находит того же пользователя по привязке
+	authURL, cookie = s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	w = s.callback(t, authURL, cookie, subject)
+	if w.Code != http.StatusOK {
+		t.Fatalf("second login = %d, want 200: %s", w.Code, w.Body)
+	}
+	var second AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if second.User.ID != first.User.ID {
+		t.Errorf("second login signed in %s, want %s", second.User.ID, first.User.ID)
+	}
+}
+
+func TestOIDCLinkCallback(t *testing.T) {
+	db := testDB(t)
+	s := newOIDCTestServer(t, db)
+
+	newUser := func() models.User {
+		t.Helper()
+		user := models.User{Username: "u" + uuid.NewString()[:12]}
+		if err := user.HashPassword("password"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		return user
+	}
+	owner, other := newUser(), newUser()
+	subject := "link-" + uuid.NewString()
+
+	s.linkUserID = owner.ID.String()
+	authURL, cookie := s.begin(t, http.MethodPost, "/api/v1/profile/identities/mock")
+	if w := s.callback(t, authURL, cookie, subject); w.Code != http.StatusOK {
+		t.Fatalf("link = %d, want 200: %s", w.Code, w.Body)
+	}
+	var identity models.UserIdentity
+	if err := db.Where("provider = ? AND subject = ?", "mock", subject).First(&identity).Error; err != nil {
+		t.Fatalf("identity was not saved: %v", err)
+	}
+	if identity.UserID != owner.ID {
+		t.Errorf("identity linked to %s, want %s", identity.UserID, owner.ID)
+	}
+
+	// Тот же внешний аккаунт нельзя привязать ко второму пользователю
+	s.linkUserID = other.ID.String()
+	authURL, cookie = s.begin(t, http.MethodPost, "/api/v1/profile/identities/mock")
+	if w := s.callback(t, authURL, cookie, subject); w.Code != http.StatusConflict {
+		t.Errorf("second link = %d, want 409: %s", w.Code, w.Body)
+	}
+
+	// Привязку, начатую в одном браузере, нельзя завершить в другом
+	authURL, _ = s.begin(t, http.MethodPost, "/api/v1/profile/identities/mock")
+	_, strangerCookie := s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	if w := s.callback(t, authURL, strangerCookie, "link-"+uuid.NewString()); w.Code != http.StatusBadRequest {
+		t.Errorf("link completed in another browser = %d, want 400", w.Code)
+	}
+}
+
+func TestOIDCBlockedUser(t *testing.T) {
+	db := testDB(t)
+	s := newOIDCTestServer(t, db)
+
+	// Вход по привязке
+	subject := "blocked-" + uuid.NewString()
+	authURL, cookie := s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	w := s.callback(t, authURL, cookie, subject)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first login = %d, want 201: %s", w.Code, w.Body)
+	}
+	var first AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &first)
+	if err := db.Model(&models.User{}).Where("id = ?", first.User.ID).Update("blocked_at", time.Now()).Error; err != nil {
+		t.Fatalf("block user: %v", err)
+	}
+	authURL, cookie = s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	if w := s.callback(t, authURL, cookie, subject); w.Code != http.StatusForbidden {
+		t.Errorf("login of blocked user = %d, want 403: %s", w.Code, w.Body)
+	}
+
+	// Автопривязка по подтвержденному email
+	subject = "blocked-" + uuid.NewString()
+	email := subject + "@example.com"
+	now := time.Now()
+	user := models.User{Username: "u" + uuid.NewString()[:12], Email: &email, EmailVerified: true, BlockedAt: &now}
+	if err := user.HashPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	authURL, cookie = s.begin(t, http.MethodGet, "/api/v1/auth/oidc/mock/start")
+	if w := s.callback(t, authURL, cookie, subject); w.Code != http.StatusForbidden {
+		t.Errorf("auto-link to blocked user = %d, want 403: %s", w.Code, w.Body)
+	}
+	var count int64
+	db.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("identity was linked to the blocked user")
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ограничения опроса
+const (
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+	minPollOptions        = 2
+	maxPollOptions        = 10
+)
+
+var errPollClosed = errors.New("poll is closed")
+
+// PollRequest опрос в запросе на отправку сообщения типа poll
+type PollRequest struct {
+	Options        []string   `json:"options"`
+	Anonymous      *bool      `json:"anonymous,omitempty"` // По умолчанию опрос анонимный
+	MultipleChoice bool       `json:"multiple_choice,omitempty"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+type PollVoteRequest struct {
+	OptionIDs []int `json:"option_ids"` // Пустой список отзывает голос
+}
+
+// model опрос для сохранения вместе с сообщением
+func (r *PollRequest) model(messageID uuid.UUID) *models.Poll {
+	poll := &models.Poll{
+		MessageID:      messageID,
+		Anonymous:      r.Anonymous == nil || *r.Anonymous,
+		MultipleChoice: r.MultipleChoice,
+		ClosesAt:       r.ClosesAt,
+	}
+	for i, text := range r.Options {
+		poll.Options = append(poll.Options, models.PollOption{MessageID: messageID, ID: i, Text: text})
+	}
+	return poll
+}
+
+// validatePoll вопрос в тексте и от 2 до 10 разных вариантов; файлы не допускаются
+func validatePoll(db *gorm.DB, senderID uuid.UUID, m *messageContent) error {
+	if strings.TrimSpace(m.Content) == "" {
+		return &messageError{Code: codeContentRequired, Field: "content", Message: "Poll question is required"}
+	}
+	if utf8.RuneCountInString(m.Content) > maxPollQuestionLength {
+		return &messageError{Code: codeContentTooLong, Field: "content", Message: "Poll question is too long"}
+	}
+	if m.MediaURL != "" || m.StickerID != nil {
+		return &messageError{Code: codeMediaNotAllowed, Field: "media_url", Message: "Polls cannot have media"}
+	}
+	if m.Poll == nil {
+		return &messageError{Code: codePollRequired, Field: "poll", Message: "poll is required"}
+	}
+
+	invalid := func(message string) error {
+		return &messageError{Code: codeInvalidPoll, Field: "poll", Message: message}
+	}
+	if len(m.Poll.Options) < minPollOptions || len(m.Poll.Options) > maxPollOptions {
+		return invalid("Poll must have between 2 and 10 options")
+	}
+	for i, option := range m.Poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return invalid("Poll options cannot be empty")
+		}
+		if utf8.RuneCountInString(option) > maxPollOptionLength {
+			return invalid("Poll option is too long")
+		}
+		for _, previous := range m.Poll.Options[:i] {
+			if strings.EqualFold(previous, option) {
+				return invalid("Poll options must be unique")
+			}
+		}
+		m.Poll.Options[i] = option
+	}
+	if m.Poll.ClosesAt != nil && !m.Poll.ClosesAt.After(time.Now()) {
+		return invalid("closes_at must be in the future")
+	}
+	return nil
+}
+
+// VotePoll голосует в опросе: выбранные варианты заменяют прежний голос пользователя,
+// пустой список отзывает его. Итоги рассылаются участникам чата событием poll_updated
+func (h *MessageHandler) VotePoll(c *gin.Context) {
+	message, ok := h.reactionTarget(c)
+	if !ok {
+		return
+	}
+	if message.IsDeleted() {
+		c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+		return
+	}
+	if message.Type != models.MessageTypePoll {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message is not a poll"})
+		return
+	}
+
+	var req PollVoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OptionIDs == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "option_ids is required"})
+		return
+	}
+	userID := c.GetString("user_id")
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Голоса одного опроса записываются по очереди: параллельные запросы
+		// пользователя не оставят ему два варианта в опросе с одним выбором
+		var poll models.Poll
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Options").
+			Where("message_id = ?", message.ID).First(&poll).Error; err != nil {
+			return err
+		}
+		if poll.IsClosed(time.Now()) {
+			return errPollClosed
+		}
+		if err := checkPollVote(&poll, req.OptionIDs); err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ? AND user_id = ?", message.ID, userUUID).Delete(&models.PollVote{}).Error; err != nil {
+			return err
+		}
+		if len(req.OptionIDs) == 0 {
+			return nil
+		}
+		votes := make([]models.PollVote, len(req.OptionIDs))
+		for i, optionID := range req.OptionIDs {
+			votes[i] = models.PollVote{MessageID: message.ID, UserID: userUUID, OptionID: optionID}
+		}
+		return tx.Create(&votes).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errPollClosed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Poll is closed"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		default:
+			if !respondMessageError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+			}
+		}
+		return
+	}
+
+	found := []models.Message{*message}
+	if err := attachPolls(h.db, found, userID); err != nil || found[0].Poll == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll results"})
+		return
+	}
+	poll := found[0].Poll
+
+	// Итоги общие для всех, выбор голосовавшего - только в его ответе
+	results := *poll
+	results.Chosen = nil
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "poll_updated",
+		ChatID: message.ChatID.String(),
+		Payload: gin.H{
+			"message_id": message.ID,
+			"chat_id":    message.ChatID,
+			"poll":       results,
+		},
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": message.ID,
+		"poll":       poll,
+	})
+}
+
+// checkPollVote варианты должны быть из опроса, без повторов; больше одного - только при множественном выборе
+func checkPollVote(poll *models.Poll, optionIDs []int) error {
+	invalid := func(message string) error {
+		return &messageError{Code: codeInvalidPoll, Field: "option_ids", Message: message}
+	}
+	if len(optionIDs) > 1 && !poll.MultipleChoice {
+		return invalid("Only one option can be chosen")
+	}
+	seen := make(map[int]bool, len(optionIDs))
+	for _, optionID := range optionIDs {
+		if optionID < 0 || optionID >= len(poll.Options) {
+			return invalid("Unknown poll option")
+		}
+		if seen[optionID] {
+			return invalid("Options must not repeat")
+		}
+		seen[optionID] = true
+	}
+	return nil
+}
+
+// GetPollVoters возвращает, кто за что проголосовал в открытом опросе (можно отфильтровать по option_id)
+func (h *MessageHandler) GetPollVoters(c *gin.Context) {
+	message, ok := h.reactionTarget(c)
+	if !ok {
+		return
+	}
+
+	var poll models.Poll
+	if err := h.db.Where("message_id = ?", message.ID).First(&poll).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	if poll.Anonymous {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Poll is anonymous"})
+		return
+	}
+
+	query := h.db.Preload("User").Where("message_id = ?", message.ID)
+	if value := c.Query("option_id"); value != "" {
+		optionID, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid option_id"})
+			return
+		}
+		query = query.Where("option_id = ?", optionID)
+	}
+
+	var votes []models.PollVote
+	if err := query.Order("created_at ASC, option_id ASC").Find(&votes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll voters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": message.ID,
+		"votes":      votes,
+	})
+}
+
+// attachPolls загружает опросы сообщений типа poll с итогами голосования
+// и вариантами, которые выбрал пользователь
+func attachPolls(db *gorm.DB, messages []models.Message, userID string) error {
+	index := make(map[uuid.UUID]int)
+	var ids []uuid.UUID
+	for i := range messages {
+		if messages[i].Type == models.MessageTypePoll && !messages[i].IsDeleted() {
+			index[messages[i].ID] = i
+			ids = append(ids, messages[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var polls []models.Poll
+	if err := db.Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("message_id IN ?", ids).Find(&polls).Error; err != nil {
+		return err
+	}
+
+	var counts []struct {
+		MessageID uuid.UUID
+		OptionID  int
+		Voters    int64
+		Chosen    bool
+	}
+	if err := db.Model(&models.PollVote{}).
+		Select("message_id, option_id, COUNT(*) AS voters, BOOL_OR(user_id = ?) AS chosen", userID).
+		Where("message_id IN ?", ids).
+		Group("message_id, option_id").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	var totals []struct {
+		MessageID uuid.UUID
+		Voters    int64
+	}
+	if err := db.Model(&models.PollVote{}).
+		Select("message_id, COUNT(DISTINCT user_id) AS voters").
+		Where("message_id IN ?", ids).
+		Group("message_id").
+		Scan(&totals).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	byMessage := make(map[uuid.UUID]*models.Poll, len(polls))
+	for i := range polls {
+		poll := &polls[i]
+		poll.Closed = poll.IsClosed(now)
+		byMessage[poll.MessageID] = poll
+		messages[index[poll.MessageID]].Poll = poll
+	}
+	for _, count := range counts {
+		poll := byMessage[count.MessageID]
+		if poll == nil || count.OptionID >= len(poll.Options) {
+			continue
+		}
+		poll.Options[count.OptionID].Voters = count.Voters
+		if count.Chosen {
+			poll.Chosen = append(poll.Chosen, count.OptionID)
+		}
+	}
+	for _, total := range totals {
+		if poll := byMessage[total.MessageID]; poll != nil {
+			poll.TotalVoters = total.Voters
+		}
+	}
+	for _, poll := range byMessage {
+		sort.Ints(poll.Chosen)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"net/http"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// AddReaction добавляет реакцию текущего пользователя на сообщение
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	var req ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.addReaction(c, req.Emoji)
+}
+
+// RemoveReaction убирает реакцию текущего пользователя
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	h.removeReaction(c, c.Param("emoji"))
+}
+
+// LikeMessage - старый лайк, теперь реакция ❤️
+func (h *MessageHandler) LikeMessage(c *gin.Context) {
+	h.addReaction(c, models.ReactionHeart)
+}
+
+// UnlikeMessage убирает реакцию ❤️
+func (h *MessageHandler) UnlikeMessage(c *gin.Context) {
+	h.removeReaction(c, models.ReactionHeart)
+}
+
+// GetReactions возвращает, кто и чем отреагировал на сообщение (можно отфильтровать по emoji)
+func (h *MessageHandler) GetReactions(c *gin.Context) {
+	message, ok := h.reactionTarget(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Preload("User").Where("message_id = ?", message.ID)
+	if emoji := c.Query("emoji"); emoji != "" {
+		query = query.Where("emoji = ?", emoji)
+	}
+
+	var reactions []models.MessageReaction
+	if err := query.Order("created_at DESC").Find(&reactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reactions"})
+		return
+	}
+
+	counts, err := messageReactions(h.db, message.ID, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": message.ID,
+		"reactions":  counts,
+		"users":      reactions,
+	})
+}
+
+// reactionTarget находит сообщение и проверяет, что пользователь состоит в его чате
+func (h *MessageHandler) reactionTarget(c *gin.Context) (*models.Message, bool) {
+	var message models.Message
+	if err := h.db.Where("id = ?", c.Param("id")).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return nil, false
+	}
+
+	var chatUser models.ChatUser
+	if err := h.db.Where("chat_id = ? AND user_id = ?", message.ChatID, c.GetString("user_id")).First(&chatUser).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return &message, true
+}
+
+func (h *MessageHandler) addReaction(c *gin.Context, emoji string) {
+	if !models.IsAllowedReaction(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reaction is not allowed", "allowed": models.AllowedReactions})
+		return
+	}
+
+	message, ok := h.reactionTarget(c)
+	if !ok {
+		return
+	}
+	if message.Type == models.MessageTypeSystem {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot react to system messages"})
+		return
+	}
+	if message.IsDeleted() {
+		c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	reaction := models.MessageReaction{
+		MessageID: message.ID,
+		UserID:    uuid.MustParse(userID),
+		Emoji:     emoji,
+	}
+	// Повторная реакция тем же эмодзи ничего не меняет
+	result := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+		return
+	}
+
+	h.respondReactions(c, message, userID, emoji, result.RowsAffected > 0, "reaction_added")
+}
+
+func (h *MessageHandler) removeReaction(c *gin.Context, emoji string) {
+	message, ok := h.reactionTarget(c)
+	if !ok {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	result := h.db.Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, userID, emoji).
+		Delete(&models.MessageReaction{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reaction not found"})
+		return
+	}
+
+	h.respondReactions(c, message, userID, emoji, true, "reaction_removed")
+}
+
+// respondReactions отдает актуальные счетчики и, если реакции изменились, рассылает событие
+func (h *MessageHandler) respondReactions(c *gin.Context, message *models.Message, userID, emoji string, changed bool, event string) {
+	counts, err := messageReactions(h.db, message.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reactions"})
+		return
+	}
+
+	if changed {
+		var count int64
+		for _, rc := range counts {
+			if rc.Emoji == emoji {
+				count = rc.Count
+			}
+		}
+		h.hub.Broadcast <- websocket.Message{
+			Type:   event,
+			ChatID: message.ChatID.String(),
+			Payload: gin.H{
+				"message_id": message.ID,
+				"user_id":    userID,
+				"emoji":      emoji,
+				"count":      count,
+			},
+		}
+	}
+
+	status := http.StatusOK
+	if changed && event == "reaction_added" {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"message_id": message.ID,
+		"reactions":  counts,
+	})
+}
+
+// messageReactions считает реакции одного сообщения
+func messageReactions(db *gorm.DB, messageID uuid.UUID, userID string) ([]models.ReactionCount, error) {
+	messages := []models.Message{{ID: messageID}}
+	if err := attachReactions(db, messages, userID); err != nil {
+		return nil, err
+	}
+	return messages[0].Reactions, nil
+}
+
+// attachReactions заполняет Reactions у сообщений одним агрегирующим запросом.
+// Эмодзи идут в порядке первой реакции
+func attachReactions(db *gorm.DB, messages []models.Message, userID string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(messages))
+	for i := range messages {
+		ids = append(ids, messages[i].ID)
+		messages[i].Reactions = []models.ReactionCount{}
+	}
+
+	var rows []struct {
+		MessageID uuid.UUID
+		models.ReactionCount
+	}
+	err := db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", userID).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("MIN(created_at)").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	index := make(map[uuid.UUID]int, len(messages))
+	for i := range messages {
+		index[messages[i].ID] = i
+	}
+	for _, row := range rows {
+		i := index[row.MessageID]
+		messages[i].Reactions = append(messages[i].Reactions, row.ReactionCount)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type MarkChatReadRequest struct {
+	MessageID string `json:"message_id"` // Если не указан - до последнего сообщения чата
+}
+
+// MarkChatAsRead сдвигает курсор прочтения пользователя в чате до указанного сообщения
+func (h *MessageHandler) MarkChatAsRead(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var chatUser models.ChatUser
+	if err := h.db.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&chatUser).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req MarkChatReadRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var message models.Message
+	query := h.db.Where("chat_id = ?", chatID)
+	if req.MessageID != "" {
+		if _, err := uuid.Parse(req.MessageID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		query = query.Where("id = ?", req.MessageID)
+	} else {
+		query = query.Order("created_at DESC, id DESC")
+	}
+	if err := query.First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark chat as read"})
+		return
+	}
+
+	if err := h.advanceReadCursor(&chatUser, message.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark chat as read"})
+		return
+	}
+
+	unread, err := unreadCount(h.db, &chatUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark chat as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chat_id":              chatUser.ChatID,
+		"last_read_message_id": chatUser.LastReadMessageID,
+		"last_read_at":         chatUser.LastReadAt,
+		"unread_count":         unread,
+	})
+}
+
+// advanceReadCursor сдвигает курсор участника вперед до сообщения (назад курсор не двигается).
+// chatUser перечитывается; если курсор сдвинулся, участникам чата уходит событие messages_read
+func (h *MessageHandler) advanceReadCursor(chatUser *models.ChatUser, messageID uuid.UUID) error {
+	moved, err := moveReadCursor(h.db, chatUser, messageID)
+	if err != nil || !moved {
+		return err
+	}
+	h.notifyRead(chatUser)
+	return nil
+}
+
+// moveReadCursor сдвигает курсор без события. Время курсора берется из БД, чтобы сравнение
+// с created_at было точным. При сдвиге упоминания до курсора отмечаются прочитанными
+func moveReadCursor(db *gorm.DB, chatUser *models.ChatUser, messageID uuid.UUID) (bool, error) {
+	result := db.Exec(`
+		UPDATE chat_users SET last_read_message_id = m.id, last_read_at = m.created_at
+		FROM messages m
+		WHERE m.id = ? AND chat_users.id = ? AND m.chat_id = chat_users.chat_id
+			AND (chat_users.last_read_at IS NULL
+				OR (chat_users.last_read_at, chat_users.last_read_message_id) < (m.created_at, m.id))`,
+		messageID, chatUser.ID)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if err := db.First(chatUser, "id = ?", chatUser.ID).Error; err != nil {
+		return false, err
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	// Упоминания до курсора пользователь уже увидел
+	return true, markMentionsRead(db, chatUser)
+}
+
+// notifyRead рассылает участникам чата новый курсор прочтения участника
+func (h *MessageHandler) notifyRead(chatUser *models.ChatUser) {
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "messages_read",
+		ChatID: chatUser.ChatID.String(),
+		Payload: gin.H{
+			"chat_id":              chatUser.ChatID,
+			"user_id":              chatUser.UserID,
+			"last_read_message_id": chatUser.LastReadMessageID,
+			"last_read_at":         chatUser.LastReadAt,
+		},
+	}
+}
+
+// unreadCount считает непрочитанные участником сообщения других пользователей
+// в ленте чата (без ответов в тредах, служебных, удаленных и скрытых им)
+func unreadCount(db *gorm.DB, chatUser *models.ChatUser) (int64, error) {
+	var count int64
+	query := db.Model(&models.Message{}).
+		Where("chat_id = ? AND thread_root_id IS NULL AND user_id <> ? AND type <> ? AND status <> ?",
+			chatUser.ChatID, chatUser.UserID, models.MessageTypeSystem, models.MessageStatusDeleted).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", chatUser.UserID)
+	if chatUser.LastReadAt != nil && chatUser.LastReadMessageID != nil {
+		query = query.Where("(created_at, id) > (?, ?)", *chatUser.LastReadAt, *chatUser.LastReadMessageID)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
+
+// messageReadStatus - статус прочтения сообщения, вычисленный по курсорам участников
+type messageReadStatus struct {
+	Status          models.MessageStatus `json:"status"`
+	ReadBy          []uuid.UUID          `json:"read_by"`
+	ReadCount       int                  `json:"read_count"`
+	RecipientsCount int                  `json:"recipients_count"`
+}
+
+// readStatus вычисляет, кто из участников (кроме автора) прочитал сообщение.
+// Сообщение считается прочитанным, когда его прочитали все получатели:
+// в личном чате - собеседник, в группе - каждый участник
+func readStatus(db *gorm.DB, message *models.Message) (*messageReadStatus, error) {
+	var participants []models.ChatUser
+	if err := db.Where("chat_id = ? AND user_id <> ?", message.ChatID, message.UserID).
+		Find(&participants).Error; err != nil {
+		return nil, err
+	}
+
+	status := &messageReadStatus{
+		Status:          message.Status,
+		ReadBy:          []uuid.UUID{},
+		RecipientsCount: len(participants),
+	}
+	for i := range participants {
+		if participants[i].HasRead(message) {
+			status.ReadBy = append(status.ReadBy, participants[i].UserID)
+		}
+	}
+	status.ReadCount = len(status.ReadBy)
+
+	allRead := status.RecipientsCount > 0 && status.ReadCount == status.RecipientsCount
+	if allRead && (message.Status == models.MessageStatusSent || message.Status == models.MessageStatusDelivered) {
+		status.Status = models.MessageStatusRead
+	}
+	return status, nil
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxScheduleAhead     = 365 * 24 * time.Hour // Насколько вперед можно отложить сообщение
+	scheduledBatchSize   = 100                  // Сколько сообщений воркер забирает за проход
+	scheduledClaimExpiry = 5 * time.Minute      // Через сколько забранное, но не отправленное сообщение забирается снова
+)
+
+var errSenderNotMember = errors.New("sender is no longer a member of the chat")
+
+type UpdateScheduledMessageRequest struct {
+	Content   *string                 `json:"content,omitempty"`
+	Entities  *models.MessageEntities `json:"entities,omitempty"`
+	ParseMode string                  `json:"parse_mode,omitempty"`
+	MediaURL  *string                 `json:"media_url,omitempty"`
+	SendAt    *time.Time              `json:"send_at,omitempty"`
+}
+
+// validSendAt проверяет, что время отправки в будущем и не дальше maxScheduleAhead
+func validSendAt(c *gin.Context, sendAt time.Time) bool {
+	now := time.Now()
+	if !sendAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be in the future"})
+		return false
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be within a year"})
+		return false
+	}
+	return true
+}
+
+// scheduleMessage откладывает отправку сообщения до req.SendAt
+func (h *MessageHandler) scheduleMessage(c *gin.Context, sender *models.ChatUser, req *SendMessageRequest) {
+	if c.GetBool("is_guest") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not available for guest accounts"})
+		return
+	}
+	if !validSendAt(c, *req.SendAt) {
+		return
+	}
+
+	// Блокировку проверяем и сейчас, и в момент отправки
+	if blocked, err := blockedByRecipient(h.db, sender.ChatID, sender.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	} else if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Recipient is blocked"})
+		return
+	}
+
+	// Ответ и тред проверяем сразу, чтобы ошибка не всплыла только в момент отправки
+	replyTo, threadRoot, err := resolveReplyTargets(h.db, sender.ChatID, req.ReplyToID, req.ThreadRootID)
+	if err != nil {
+		if errors.Is(err, errInvalidReplyTo) || errors.Is(err, errInvalidThreadRoot) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	}
+
+	scheduled := models.ScheduledMessage{
+		ChatID:    sender.ChatID,
+		UserID:    sender.UserID,
+		Type:      models.MessageType(req.Type),
+		Content:   req.Content,
+		Entities:  req.Entities,
+		MediaURL:  req.MediaURL,
+		ReplyToID: replyTo,
+		SendAt:    req.SendAt.UTC(),
+		TTL:       req.TTL,
+	}
+	if threadRoot != nil {
+		scheduled.ThreadRootID = &threadRoot.ID
+	}
+	if req.ClientMessageID != "" {
+		scheduled.ClientMessageID = &req.ClientMessageID
+	}
+	if scheduled.StickerID, err = parseStickerID(req.StickerID); err != nil {
+		respondMessageError(c, err)
+		return
+	}
+	result := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&scheduled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	}
+
+	// Повтор с тем же client_message_id: отдаем уже запланированное сообщение
+	if result.RowsAffected == 0 {
+		var existing models.ScheduledMessage
+		if err := h.db.Where("user_id = ? AND client_message_id = ?", sender.UserID, req.ClientMessageID).
+			First(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+			return
+		}
+		if existing.ChatID != sender.ChatID {
+			c.JSON(http.StatusConflict, gin.H{"error": errClientMessageIDReused.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, scheduled)
+}
+
+// GetScheduledMessages отдает ожидающие отправки сообщения пользователя в чате
+func (h *MessageHandler) GetScheduledMessages(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	var count int64
+	h.db.Model(&models.ChatUser{}).Where("chat_id = ? AND user_id = ?", chatID, userID).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var scheduled []models.ScheduledMessage
+	if err := h.db.Where("chat_id = ? AND user_id = ? AND status = ?", chatID, userID, models.ScheduledPending).
+		Order("send_at ASC").Find(&scheduled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"scheduled_messages": scheduled})
+}
+
+// UpdateScheduledMessage меняет текст, медиа или время отправки, пока сообщение ожидает отправки
+func (h *MessageHandler) UpdateScheduledMessage(c *gin.Context) {
+	var req UpdateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if req.Content != nil {
+		updates["content"] = *req.Content
+	}
+	if req.MediaURL != nil {
+		updates["media_url"] = *req.MediaURL
+	}
+	if req.Entities != nil {
+		updates["entities"] = *req.Entities
+	}
+	if req.SendAt != nil {
+		if !validSendAt(c, *req.SendAt) {
+			return
+		}
+		updates["send_at"] = req.SendAt.UTC()
+	}
+	if len(updates) == 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	// Новое содержимое проверяется по правилам типа, как при отправке
+	if req.Content != nil || req.Entities != nil || req.MediaURL != nil {
+		var scheduled models.ScheduledMessage
+		if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetString("user_id")).First(&scheduled).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+			return
+		}
+		if scheduled.Type == models.MessageTypeSticker {
+			respondMessageError(c, &messageError{Code: codeContentNotAllowed, Field: "content", Message: "Only send_at of a sticker can be changed"})
+			return
+		}
+
+		edited := messageContent{
+			Type:         scheduled.Type,
+			Content:      scheduled.Content,
+			Entities:     scheduled.Entities,
+			MediaURL:     scheduled.MediaURL,
+			KeptMediaURL: scheduled.MediaURL,
+		}
+		if req.Content != nil {
+			edited.Content = *req.Content
+			edited.Entities = nil
+			edited.ParseMode = req.ParseMode
+		}
+		if req.Entities != nil {
+			edited.Entities = *req.Entities
+		}
+		if req.MediaURL != nil {
+			edited.MediaURL = *req.MediaURL
+		}
+		if err := validateMessage(h.db, scheduled.UserID, &edited); err != nil {
+			if !respondMessageError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+			}
+			return
+		}
+		updates["content"] = edited.Content
+		updates["entities"] = edited.Entities
+	}
+
+	h.changeScheduledMessage(c, updates)
+}
+
+// CancelScheduledMessage отменяет отправку, пока сообщение ожидает отправки
+func (h *MessageHandler) CancelScheduledMessage(c *gin.Context) {
+	h.changeScheduledMessage(c, map[string]interface{}{
+		"status":     models.ScheduledCancelled,
+		"updated_at": time.Now(),
+	})
+}
+
+// changeScheduledMessage применяет изменения одним UPDATE с условием status = 'pending',
+// поэтому сообщение, уже забранное воркером, изменить или отменить нельзя
+func (h *MessageHandler) changeScheduledMessage(c *gin.Context, updates map[string]interface{}) {
+	scheduledID := c.Param("id")
+	userID := c.GetString("user_id")
+
+	result := h.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND user_id = ? AND status = ?", scheduledID, userID, models.ScheduledPending).
+		Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+
+	var scheduled models.ScheduledMessage
+	if err := h.db.Where("id = ? AND user_id = ?", scheduledID, userID).First(&scheduled).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduled message is no longer pending"})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+// DeliverScheduledMessages отправляет сообщения, время которых пришло.
+// Строки забираются через SKIP LOCKED, поэтому несколько воркеров не отправят одно сообщение дважды.
+// Строка отмечается отправленной в той же транзакции, что создает сообщение. Если воркер упал
+// посреди отправки, строка через scheduledClaimExpiry забирается снова: сообщение создается
+// с ID отложенного и повторно не появляется, а рассылка повторяется, пока строка не отмечена
+func (h *MessageHandler) DeliverScheduledMessages() error {
+	for {
+		var batch []models.ScheduledMessage
+		err := h.db.Raw(`
+			UPDATE scheduled_messages SET status = @sending, updated_at = now()
+			WHERE id IN (
+				SELECT id FROM scheduled_messages
+				WHERE (status = @pending AND send_at <= now())
+					OR (status = @sending AND updated_at < @stale)
+				ORDER BY send_at
+				LIMIT @limit
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *`, map[string]interface{}{
+			"sending": models.ScheduledSending,
+			"pending": models.ScheduledPending,
+			"stale":   time.Now().Add(-scheduledClaimExpiry),
+			"limit":   scheduledBatchSize,
+		}).Scan(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		for i := range batch {
+			h.deliverScheduled(&batch[i])
+		}
+		if len(batch) < scheduledBatchSize {
+			return nil
+		}
+	}
+}
+
+// deliverScheduled отправляет одно забранное сообщение обычным путем SendMessage
+func (h *MessageHandler) deliverScheduled(scheduled *models.ScheduledMessage) {
+	if err := h.sendScheduled(scheduled); err != nil {
+		if errors.Is(err, errSenderNotMember) || errors.Is(err, errInvalidReplyTo) ||
+			errors.Is(err, errInvalidThreadRoot) || errors.Is(err, errClientMessageIDReused) ||
+			errors.Is(err, errRecipientBlocked) {
+			h.db.Model(scheduled).Updates(map[string]interface{}{
+				"status":     models.ScheduledFailed,
+				"error":      err.Error(),
+				"updated_at": time.Now(),
+			})
+			return
+		}
+		// Временная ошибка: строка останется в sending и будет забрана повторно
+		log.Printf("Failed to deliver scheduled message %s: %v", scheduled.ID, err)
+	}
+}
+
+func (h *MessageHandler) sendScheduled(scheduled *models.ScheduledMessage) error {
+	var sender models.ChatUser
+	if err := h.db.Where("chat_id = ? AND user_id = ?", scheduled.ChatID, scheduled.UserID).First(&sender).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errSenderNotMember
+		}
+		return err
+	}
+
+	req := SendMessageRequest{
+		Type:     string(scheduled.Type),
+		Content:  scheduled.Content,
+		Entities: scheduled.Entities,
+		MediaURL: scheduled.MediaURL,
+		TTL:      scheduled.TTL,
+	}
+	if scheduled.ReplyToID != nil {
+		req.ReplyToID = scheduled.ReplyToID.String()
+	}
+	if scheduled.ThreadRootID != nil {
+		req.ThreadRootID = scheduled.ThreadRootID.String()
+	}
+	if scheduled.ClientMessageID != nil {
+		req.ClientMessageID = *scheduled.ClientMessageID
+	}
+	if scheduled.StickerID != nil {
+		req.StickerID = scheduled.StickerID.String()
+	}
+
+	_, _, err := h.sendMessage(&sender, &req, scheduled)
+	return err
+}
+
+// markScheduledSent отмечает забранную воркером строку отправленной; false - ее уже отметила другая попытка
+func markScheduledSent(db *gorm.DB, scheduledID uuid.UUID) (bool, error) {
+	result := db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduledID, models.ScheduledSending).
+		Updates(map[string]interface{}{
+			"status":     models.ScheduledSent,
+			"message_id": scheduledID,
+			"error":      "",
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// resumeScheduled доводит до конца отправку, начатую прошлой попыткой: сообщение уже создано,
+// но строка не отмечена отправленной, значит рассылка могла не дойти до участников
+func (h *MessageHandler) resumeScheduled(scheduled *models.ScheduledMessage, sender *models.ChatUser, message *models.Message) error {
+	var threadRoot *models.Message
+	if message.ThreadRootID != nil {
+		threadRoot = &models.Message{}
+		if err := h.db.Where("id = ?", *message.ThreadRootID).First(threadRoot).Error; err != nil {
+			return err
+		}
+	}
+
+	marked, err := markScheduledSent(h.db, scheduled.ID)
+	if err != nil || !marked {
+		return err
+	}
+	h.publishMessage(message, sender, threadRoot)
+	return nil
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Метки подсветки в сниппетах ts_headline
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// Конфигурация russian стеммит кириллицу русским стеммером, а латиницу - английским,
+// поэтому одной колонки search_vector хватает для обоих языков
+const searchConfig = "russian"
+
+// searchResultRow - найденное сообщение со сниппетом
+type searchResultRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Snippet   string
+}
+
+// SearchResult - элемент выдачи поиска
+type SearchResult struct {
+	Message models.Message `json:"message"`
+	Snippet string         `json:"snippet"`
+}
+
+// SearchMessages ищет по сообщениям всех чатов пользователя (можно сузить параметром chat_id)
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	h.searchMessages(c, c.Query("chat_id"))
+}
+
+// SearchChatMessages ищет по сообщениям одного чата
+func (h *MessageHandler) SearchChatMessages(c *gin.Context) {
+	h.searchMessages(c, c.Param("id"))
+}
+
+func (h *MessageHandler) searchMessages(c *gin.Context, chatID string) {
+	userID := c.GetString("user_id")
+	limit := pageLimit(c, 20, 50)
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+
+	// Поиск только по чатам, где пользователь сейчас состоит
+	filters := []string{"chat_users.user_id = @user"}
+	args := []interface{}{
+		sql.Named("user", userID),
+		sql.Named("q", q),
+		sql.Named("limit", limit+1),
+		sql.Named("system", models.MessageTypeSystem),
+		sql.Named("deleted", models.MessageStatusDeleted),
+	}
+
+	if chatID != "" {
+		if _, err := uuid.Parse(chatID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+			return
+		}
+		var count int64
+		h.db.Model(&models.ChatUser{}).Where("chat_id = ? AND user_id = ?", chatID, userID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		filters = append(filters, "m.chat_id = @chat")
+		args = append(args, sql.Named("chat", chatID))
+	}
+	if sender := c.Query("sender_id"); sender != "" {
+		if _, err := uuid.Parse(sender); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sender ID"})
+			return
+		}
+		filters = append(filters, "m.user_id = @sender")
+		args = append(args, sql.Named("sender", sender))
+	}
+	if msgType := c.Query("type"); msgType != "" {
+		filters = append(filters, "m.type = @type")
+		args = append(args, sql.Named("type", msgType))
+	}
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseSearchTime(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + " date, use RFC3339 or YYYY-MM-DD"})
+			return
+		}
+		filters = append(filters, "m.created_at "+bound.op+" @"+bound.param)
+		args = append(args, sql.Named(bound.param, t))
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		filters = append(filters, "(m.created_at, m.id) < (@cursor_time, @cursor_id)")
+		args = append(args, sql.Named("cursor_time", cur.Time), sql.Named("cursor_id", cur.ID))
+	}
+
+	var rows []searchResultRow
+	err := h.db.Raw(`
+		SELECT m.id, m.created_at,
+			ts_headline('`+searchConfig+`', m.content, query,
+				'StartSel=`+highlightStart+`, StopSel=`+highlightStop+`, MaxWords=30, MinWords=10, MaxFragments=2') AS snippet
+		FROM messages m
+		JOIN chat_users ON chat_users.chat_id = m.chat_id,
+			websearch_to_tsquery('`+searchConfig+`', @q) AS query
+		WHERE m.search_vector @@ query
+			AND m.type <> @system
+			AND m.status <> @deleted
+			AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = @user)
+			AND `+strings.Join(filters, " AND ")+`
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT @limit`,
+		args...,
+	).Scan(&rows).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	if len(rows) > 0 {
+		ids := make([]uuid.UUID, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		var messages []models.Message
+		if err := h.db.Preload("User").Preload("Chat").Preload("Mentions").Where("id IN ?", ids).Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+			return
+		}
+		if err := attachReactions(h.db, messages, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+			return
+		}
+		if err := attachPolls(h.db, messages, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+			return
+		}
+		byID := make(map[uuid.UUID]models.Message, len(messages))
+		for _, m := range messages {
+			byID[m.ID] = m
+		}
+		for _, row := range rows {
+			if m, ok := byID[row.ID]; ok {
+				results = append(results, SearchResult{Message: m, Snippet: escapeSnippet(row.Snippet)})
+			}
+		}
+	}
+
+	response := gin.H{
+		"results":  results,
+		"limit":    limit,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		last := rows[len(rows)-1]
+		response["next_cursor"] = encodeCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseSearchTime разбирает дату фильтра: RFC3339 или YYYY-MM-DD
+func parseSearchTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// escapeSnippet экранирует HTML в сниппете, оставляя только метки подсветки
+func escapeSnippet(snippet string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(snippet, highlightStart)
+		if start < 0 {
+			break
+		}
+		stop := strings.Index(snippet[start:], highlightStop)
+		if stop < 0 {
+			break
+		}
+		stop += start
+		b.WriteString(html.EscapeString(snippet[:start]))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(snippet[start+len(highlightStart) : stop]))
+		b.WriteString(highlightStop)
+		snippet = snippet[stop+len(highlightStop):]
+	}
+	b.WriteString(html.EscapeString(snippet))
+	return b.String()
+}
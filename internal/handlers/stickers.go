@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type StickerHandler struct {
+	db *gorm.DB
+}
+
+func NewStickerHandler(db *gorm.DB) *StickerHandler {
+	return &StickerHandler{db: db}
+}
+
+type CreateStickerRequest struct {
+	Pack     string `json:"pack" binding:"required"`
+	Emoji    string `json:"emoji" binding:"required"`
+	MediaURL string `json:"media_url" binding:"required"` // Своя загрузка: картинка или видео
+}
+
+// GetStickers возвращает каталог стикеров, по возможности одного набора (?pack=)
+func (h *StickerHandler) GetStickers(c *gin.Context) {
+	query := h.db.Order("pack ASC, created_at ASC")
+	if pack := c.Query("pack"); pack != "" {
+		query = query.Where("pack = ?", pack)
+	}
+
+	var stickers []models.Sticker
+	if err := query.Find(&stickers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stickers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stickers": stickers})
+}
+
+// CreateSticker добавляет стикер в каталог из загруженного администратором файла
+func (h *StickerHandler) CreateSticker(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req CreateStickerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Файл стикера должен быть своей загрузкой, как медиа в сообщении
+	media := messageContent{MediaURL: req.MediaURL}
+	if err := validateMedia(isStickerUpload)(h.db, userUUID, &media); err != nil {
+		if !respondMessageError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sticker"})
+		}
+		return
+	}
+
+	sticker := models.Sticker{
+		Pack:      req.Pack,
+		Emoji:     req.Emoji,
+		MediaURL:  req.MediaURL,
+		CreatedBy: userUUID,
+	}
+	if err := h.db.Create(&sticker).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sticker"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, sticker)
+}
+
+// DeleteSticker убирает стикер из каталога. Уже отправленные стикеры остаются в чатах
+func (h *StickerHandler) DeleteSticker(c *gin.Context) {
+	result := h.db.Delete(&models.Sticker{}, "id = ?", c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sticker"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sticker not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sticker deleted"})
+}
+
+// isStickerUpload стикер - картинка (png, webp, gif) или короткое видео
+func isStickerUpload(u *models.Upload) bool {
+	return u.MediaType == "image" || u.MediaType == "video"
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidReplyTo    = errors.New("reply_to_id must reference a message in this chat")
+	errInvalidThreadRoot = errors.New("thread_root_id must reference a message in this chat")
+)
+
+// resolveReplyTargets проверяет reply_to_id и thread_root_id: оба должны ссылаться на сообщения этого чата.
+// Если корнем указан ответ из треда, используется корень этого треда
+func resolveReplyTargets(db *gorm.DB, chatID uuid.UUID, replyToID, threadRootID string) (*uuid.UUID, *models.Message, error) {
+	var replyTo *uuid.UUID
+	if replyToID != "" {
+		id, err := uuid.Parse(replyToID)
+		if err != nil {
+			return nil, nil, errInvalidReplyTo
+		}
+		var count int64
+		if err := db.Model(&models.Message{}).Where("id = ? AND chat_id = ?", id, chatID).Count(&count).Error; err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			return nil, nil, errInvalidReplyTo
+		}
+		replyTo = &id
+	}
+
+	if threadRootID == "" {
+		return replyTo, nil, nil
+	}
+	id, err := uuid.Parse(threadRootID)
+	if err != nil {
+		return nil, nil, errInvalidThreadRoot
+	}
+	var root models.Message
+	if err := db.Where("id = ? AND chat_id = ?", id, chatID).First(&root).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errInvalidThreadRoot
+		}
+		return nil, nil, err
+	}
+	if root.ThreadRootID != nil {
+		if err := db.Where("id = ?", *root.ThreadRootID).First(&root).Error; err != nil {
+			return nil, nil, errInvalidThreadRoot
+		}
+	}
+	if root.Type == models.MessageTypeSystem || root.IsDeleted() {
+		return nil, nil, errInvalidThreadRoot
+	}
+	return replyTo, &root, nil
+}
+
+// recordThreadReply атомарно обновляет сводку треда у корневого сообщения.
+// Последний ответ не откатывается назад, если параллельный более новый ответ записан раньше
+func recordThreadReply(tx *gorm.DB, root *models.Message, reply *models.Message) error {
+	newer := "thread_last_reply_at IS NULL OR thread_last_reply_at <= ?"
+	return tx.Model(&models.Message{}).Where("id = ?", root.ID).Updates(map[string]interface{}{
+		"thread_reply_count":   gorm.Expr("thread_reply_count + 1"),
+		"thread_last_reply_id": gorm.Expr("CASE WHEN "+newer+" THEN ? ELSE thread_last_reply_id END", reply.CreatedAt, reply.ID),
+		"thread_last_reply_at": gorm.Expr("CASE WHEN "+newer+" THEN ? ELSE thread_last_reply_at END", reply.CreatedAt, reply.CreatedAt),
+	}).Error
+}
+
+// recountThreads пересчитывает сводки тредов после удаления ответов
+func recountThreads(tx *gorm.DB, rootIDs []uuid.UUID) error {
+	if len(rootIDs) == 0 {
+		return nil
+	}
+	return tx.Exec(`
+		UPDATE messages r SET
+			thread_reply_count = (SELECT COUNT(*) FROM messages m WHERE m.thread_root_id = r.id),
+			thread_last_reply_id = last.id,
+			thread_last_reply_at = last.created_at
+		FROM messages r2
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.created_at FROM messages m
+			WHERE m.thread_root_id = r2.id
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT 1
+		) last ON true
+		WHERE r.id = r2.id AND r.id IN ?`, rootIDs).Error
+}
+
+// notifyThread рассылает обновленную сводку треда всему чату, а участникам треда
+// (автору корня и всем ответившим, кроме отправителя и выключивших уведомления) - уведомление thread_reply
+func (h *MessageHandler) notifyThread(root *models.Message, reply *models.Message) {
+	if err := h.db.First(root, "id = ?", root.ID).Error; err != nil {
+		return
+	}
+	chatID := root.ChatID.String()
+
+	h.hub.Broadcast <- websocket.Message{
+		Type:   "thread_updated",
+		ChatID: chatID,
+		Payload: gin.H{
+			"root_id":              root.ID,
+			"thread_reply_count":   root.ThreadReplyCount,
+			"thread_last_reply_id": root.ThreadLastReplyID,
+			"thread_last_reply_at": root.ThreadLastReplyAt,
+		},
+	}
+
+	// Покинувшие чат участники треда thread_reply не получают. Выключившие уведомления чата
+	// не получают его, если ответ их не упоминает
+	members := h.db.Model(&models.ChatUser{}).Select("user_id").Where("chat_id = ?", root.ChatID)
+	muted := h.db.Model(&models.ChatUser{}).Select("user_id").
+		Where("chat_id = ? AND muted_until > ?", root.ChatID, time.Now())
+	query := h.db.Model(&models.Message{}).
+		Where("(id = ? OR thread_root_id = ?) AND user_id <> ?", root.ID, root.ID, reply.UserID).
+		Where("user_id IN (?)", members)
+	if mentioned := mentionedUserIDs(reply.Mentions); len(mentioned) > 0 {
+		query = query.Where("(user_id NOT IN (?) OR user_id IN ?)", muted, mentioned)
+	} else {
+		query = query.Where("user_id NOT IN (?)", muted)
+	}
+
+	var participants []string
+	if err := query.Distinct().Pluck("user_id", &participants).Error; err != nil || len(participants) == 0 {
+		return
+	}
+	h.hub.Broadcast <- websocket.Message{
+		Type:       "thread_reply",
+		ChatID:     chatID,
+		Recipients: participants,
+		AllChats:   true,
+		Payload: gin.H{
+			"root_id": root.ID,
+			"message": reply,
+		},
+	}
+}
+
+// GetThread возвращает корневое сообщение и ответы в треде от старых к новым.
+// Следующая страница - параметр after (курсор из ответа)
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	messageID := c.Param("id")
+	userID := c.GetString("user_id")
+	limit := pageLimit(c, 50, 100)
+
+	var root models.Message
+	if err := h.db.Preload("User").Preload("Mentions").Where("id = ?", messageID).First(&root).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	// Проверяем доступ к чату
+	var chatUser models.ChatUser
+	if err := h.db.Where("chat_id = ? AND user_id = ?", root.ChatID, userID).First(&chatUser).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if root.ThreadRootID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message is a thread reply, use its thread_root_id", "thread_root_id": root.ThreadRootID})
+		return
+	}
+
+	query := h.db.Preload("User").
+		Preload("ReplyTo").
+		Preload("ReplyTo.User").
+		Preload("Mentions").
+		Where("thread_root_id = ?", root.ID).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", userID)
+	if raw := c.Query("after"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("(created_at, id) > (?, ?)", cur.Time, cur.ID)
+	}
+
+	var replies []models.Message
+	if err := query.Order("created_at ASC, id ASC").Limit(limit + 1).Find(&replies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	hasMore := len(replies) > limit
+	if hasMore {
+		replies = replies[:limit]
+	}
+
+	roots := []models.Message{root}
+	if err := attachReactions(h.db, roots, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	if err := attachPolls(h.db, roots, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	if err := attachReactions(h.db, replies, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	if err := attachPolls(h.db, replies, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+
+	response := gin.H{
+		"root":     roots[0],
+		"replies":  replies,
+		"limit":    limit,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		last := replies[len(replies)-1]
+		response["next_cursor"] = encodeCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
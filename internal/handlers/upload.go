@@ -9,16 +9,20 @@ import (
 	"strings"
 	"time"
 
+	"swirl-backend/internal/models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type UploadHandler struct {
+	db         *gorm.DB
 	uploadPath string
 }
 
-func NewUploadHandler(uploadPath string) *UploadHandler {
-	return &UploadHandler{uploadPath: uploadPath}
+func NewUploadHandler(db *gorm.DB, uploadPath string) *UploadHandler {
+	return &UploadHandler{db: db, uploadPath: uploadPath}
 }
 
 func (h *UploadHandler) UploadFile(c *gin.Context) {
@@ -83,13 +87,31 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 	// Определяем тип медиа
 	mediaType := h.getMediaType(contentType)
 
+	// Запоминаем владельца: в сообщениях можно ссылаться только на свои загрузки
+	upload := models.Upload{
+		FileName:    fileName,
+		URL:         fmt.Sprintf("/uploads/%s/%s", userID, fileName),
+		ContentType: contentType,
+		MediaType:   mediaType,
+		Size:        header.Size,
+	}
+	if upload.UserID, err = uuid.Parse(userID); err == nil {
+		err = h.db.Create(&upload).Error
+	}
+	if err != nil {
+		os.Remove(filePath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
 	// Получаем дополнительную информацию о файле
 	fileInfo := h.getFileInfo(filePath, contentType)
 
 	// Возвращаем информацию о загруженном файле
 	c.JSON(http.StatusOK, gin.H{
+		"upload_id":  upload.ID,
 		"file_id":    fileName,
-		"file_url":   fmt.Sprintf("/uploads/%s/%s", userID, fileName),
+		"file_url":   upload.URL,
 		"media_type": mediaType,
 		"size":       header.Size,
 		"created_at": time.Now(),
@@ -143,6 +165,7 @@ func (h *UploadHandler) DeleteFile(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
 		return
 	}
+	h.db.Where("user_id = ? AND file_name = ?", userID, fileName).Delete(&models.Upload{})
 
 	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
 }
@@ -206,3 +229,18 @@ func (h *UploadHandler) getFileInfo(filePath, contentType string) map[string]int
 
 	return info
 }
+
+// uploadedFilePath переводит media_url загруженного файла (/uploads/{user_id}/{file_name})
+// в путь на диске. Для внешних и некорректных ссылок возвращает false
+func uploadedFilePath(uploadPath, mediaURL string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(mediaURL, "/uploads/"), "/")
+	if !strings.HasPrefix(mediaURL, "/uploads/") || len(parts) != 2 {
+		return "", false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || strings.Contains(part, "..") {
+			return "", false
+		}
+	}
+	return filepath.Join(uploadPath, parts[0], parts[1]), true
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Ограничения длины текста
+const (
+	maxTextLength    = 4096 // Текст и ответ
+	maxCaptionLength = 1024 // Подпись к медиа
+)
+
+// Коды ошибок проверки сообщения, отдаются в поле code
+const (
+	codeUnknownType       = "unknown_type"
+	codeTypeNotAllowed    = "type_not_allowed"
+	codeContentRequired   = "content_required"
+	codeContentTooLong    = "content_too_long"
+	codeContentNotAllowed = "content_not_allowed"
+	codeMediaRequired     = "media_required"
+	codeMediaNotAllowed   = "media_not_allowed"
+	codeMediaNotFound     = "media_not_found"
+	codeMediaNotOwned     = "media_not_owned"
+	codeMediaTypeMismatch = "media_type_mismatch"
+	codeStickerRequired   = "sticker_required"
+	codeStickerNotFound   = "sticker_not_found"
+	codeInvalidEntities   = "invalid_entities"
+	codePollRequired      = "poll_required"
+	codeInvalidPoll       = "invalid_poll"
+	codePollNotEditable   = "poll_not_editable"
+)
+
+// messageError ошибка проверки сообщения с машинно-читаемым кодом и полем запроса
+type messageError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"error"`
+}
+
+func (e *messageError) Error() string {
+	return e.Message
+}
+
+// respondMessageError пишет 400 с кодом ошибки проверки; возвращает false для других ошибок
+func respondMessageError(c *gin.Context, err error) bool {
+	var invalid *messageError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, invalid)
+	return true
+}
+
+// messageContent содержимое сообщения, которое проверяется перед отправкой и при правке.
+// Проверка может дополнить его: стикер получает media_url и эмодзи из каталога
+type messageContent struct {
+	Type      models.MessageType
+	Content   string
+	MediaURL  string
+	StickerID *uuid.UUID
+
+	// Форматирование: фрагменты от клиента или разметка в Content (ParseMode).
+	// После проверки в Entities итоговые фрагменты, а ParseMode сброшен
+	Entities  models.MessageEntities
+	ParseMode string
+
+	// Опрос, только для type=poll
+	Poll *PollRequest
+
+	// Файл, который уже был в сообщении до правки: его принадлежность не проверяется повторно
+	KeptMediaURL string
+}
+
+type messageValidator func(db *gorm.DB, senderID uuid.UUID, m *messageContent) error
+
+// messageValidators проверки по типам сообщений. Тип без проверки отправить нельзя
+var messageValidators = map[models.MessageType]messageValidator{
+	models.MessageTypeText:    validateText,
+	models.MessageTypeReply:   validateText,
+	models.MessageTypeImage:   validateMedia(isImageUpload),
+	models.MessageTypeVideo:   validateMedia(isVideoUpload),
+	models.MessageTypeVoice:   validateMedia(isVoiceUpload),
+	models.MessageTypeGif:     validateMedia(isGifUpload),
+	models.MessageTypeSticker: validateSticker,
+	models.MessageTypePoll:    validatePoll,
+}
+
+// parseStickerID разбирает sticker_id из запроса (пустая строка - стикера нет)
+func parseStickerID(value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, &messageError{Code: codeStickerNotFound, Field: "sticker_id", Message: "Sticker not found"}
+	}
+	return &id, nil
+}
+
+// validateMessage разбирает форматирование и проверяет содержимое по правилам его типа
+func validateMessage(db *gorm.DB, senderID uuid.UUID, m *messageContent) error {
+	if m.Type == models.MessageTypeSystem {
+		return &messageError{Code: codeTypeNotAllowed, Field: "type", Message: "System messages cannot be sent"}
+	}
+	validate, ok := messageValidators[m.Type]
+	if !ok {
+		return &messageError{Code: codeUnknownType, Field: "type", Message: "Unknown message type"}
+	}
+	if m.Poll != nil && m.Type != models.MessageTypePoll {
+		return &messageError{Code: codeContentNotAllowed, Field: "poll", Message: "Only poll messages can have a poll"}
+	}
+	if err := formatContent(m); err != nil {
+		return err
+	}
+	return validate(db, senderID, m)
+}
+
+// validateText текст обязателен, медиа и стикер не допускаются
+func validateText(db *gorm.DB, senderID uuid.UUID, m *messageContent) error {
+	if strings.TrimSpace(m.Content) == "" {
+		return &messageError{Code: codeContentRequired, Field: "content", Message: "Content is required"}
+	}
+	if utf8.RuneCountInString(m.Content) > maxTextLength {
+		return &messageError{Code: codeContentTooLong, Field: "content", Message: "Content is too long"}
+	}
+	if m.MediaURL != "" {
+		return &messageError{Code: codeMediaNotAllowed, Field: "media_url", Message: "Text messages cannot have media"}
+	}
+	if m.StickerID != nil {
+		return &messageError{Code: codeMediaNotAllowed, Field: "sticker_id", Message: "Text messages cannot have a sticker"}
+	}
+	return nil
+}
+
+// validateMedia файл обязателен и должен быть загрузкой отправителя подходящего типа, подпись необязательна
+func validateMedia(accepts func(upload *models.Upload) bool) messageValidator {
+	return func(db *gorm.DB, senderID uuid.UUID, m *messageContent) error {
+		if utf8.RuneCountInString(m.Content) > maxCaptionLength {
+			return &messageError{Code: codeContentTooLong, Field: "content", Message: "Caption is too long"}
+		}
+		if m.StickerID != nil {
+			return &messageError{Code: codeMediaNotAllowed, Field: "sticker_id", Message: "Only sticker messages can have a sticker"}
+		}
+		if m.MediaURL == "" {
+			return &messageError{Code: codeMediaRequired, Field: "media_url", Message: "Media is required"}
+		}
+		if m.MediaURL == m.KeptMediaURL {
+			return nil
+		}
+
+		var upload models.Upload
+		if err := db.Where("url = ?", m.MediaURL).First(&upload).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &messageError{Code: codeMediaNotFound, Field: "media_url", Message: "media_url must reference an uploaded file"}
+			}
+			return err
+		}
+		if upload.UserID != senderID {
+			return &messageError{Code: codeMediaNotOwned, Field: "media_url", Message: "media_url must reference your own upload"}
+		}
+		if !accepts(&upload) {
+			return &messageError{Code: codeMediaTypeMismatch, Field: "media_url", Message: "Uploaded file does not match the message type"}
+		}
+		return nil
+	}
+}
+
+func isImageUpload(u *models.Upload) bool { return u.MediaType == "image" }
+func isVideoUpload(u *models.Upload) bool { return u.MediaType == "video" }
+func isVoiceUpload(u *models.Upload) bool { return u.MediaType == "voice" }
+
+// isGifUpload GIF-анимация: сам gif или беззвучное видео, в которое ее конвертируют клиенты
+func isGifUpload(u *models.Upload) bool {
+	return u.ContentType == "image/gif" || u.MediaType == "video"
+}
+
+// validateSticker стикер должен быть в каталоге; файл и подпись берутся из каталога
+func validateSticker(db *gorm.DB, senderID uuid.UUID, m *messageContent) error {
+	if m.StickerID == nil {
+		return &messageError{Code: codeStickerRequired, Field: "sticker_id", Message: "sticker_id is required"}
+	}
+	if m.MediaURL != "" {
+		return &messageError{Code: codeMediaNotAllowed, Field: "media_url", Message: "Sticker media comes from the catalog"}
+	}
+	if m.Content != "" {
+		return &messageError{Code: codeContentNotAllowed, Field: "content", Message: "Sticker messages cannot have content"}
+	}
+
+	var sticker models.Sticker
+	if err := db.Where("id = ?", *m.StickerID).First(&sticker).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &messageError{Code: codeStickerNotFound, Field: "sticker_id", Message: "Sticker not found"}
+		}
+		return err
+	}
+	m.MediaURL = sticker.MediaURL
+	m.Content = sticker.Emoji
+	return nil
+}
@@ -0,0 +1,16 @@
+package mailer
+
+import "log"
+
+// LogMailer пишет письма в лог вместо отправки (для разработки)
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send выводит письмо в лог
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("📧 Mail to %s | %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Message письмо для отправки пользователю
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer отправляет письма пользователям
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config настройки почтового транспорта
+type Config struct {
+	Driver   string // "smtp" или "log"
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// New создает Mailer по драйверу из конфигурации
+func New(cfg Config) (Mailer, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "log":
+		return NewLogMailer(), nil
+	case "smtp":
+		if cfg.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mailer requires host and from address")
+		}
+		return NewSMTPMailer(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver: %s", cfg.Driver)
+	}
+}
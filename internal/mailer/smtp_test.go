@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"mime"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP принимает одно письмо и запоминает конверт и текст
+type fakeSMTP struct {
+	listener net.Listener
+	auth     string
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeSMTP{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH PLAIN"):
+			s.auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+			reply("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = line[len("MAIL FROM:"):]
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, line[len("RCPT TO:"):])
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func TestSMTPMailerSendsResetMail(t *testing.T) {
+	server := startFakeSMTP(t)
+	m := NewSMTPMailer(Config{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "swirl",
+		Password: "secret",
+		From:     "noreply@swirl.test",
+	})
+
+	link := "http://localhost:8080/reset-password?token=abc123"
+	err := m.Send(Message{
+		To:      "vasya@example.com",
+		Subject: "Swirl: сброс пароля",
+		Body:    "Привет, vasya!\n\nЧтобы задать новый пароль, перейди по ссылке:\n" + link,
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+
+	if server.from != "<noreply@swirl.test>" {
+		t.Errorf("MAIL FROM = %q", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "<vasya@example.com>" {
+		t.Errorf("RCPT TO = %q", server.to)
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(server.auth)
+	if string(credentials) != "\x00swirl\x00secret" {
+		t.Errorf("AUTH PLAIN credentials = %q", credentials)
+	}
+
+	for _, want := range []string{
+		"From: noreply@swirl.test\r\n",
+		"To: vasya@example.com\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\nПривет, vasya!\r\n\r\n",
+		link + "\r\n",
+	} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, server.data)
+		}
+	}
+
+	// Тема в заголовке только ASCII и раскодируется в исходную
+	subject := headerLine(server.data, "Subject: ")
+	decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+	if err != nil || decoded != "Swirl: сброс пароля" {
+		t.Errorf("Subject %q decodes to %q, %v", subject, decoded, err)
+	}
+	for _, r := range subject {
+		if r > 127 {
+			t.Errorf("Subject is not ASCII: %q", subject)
+			break
+		}
+	}
+}
+
+// headerLine значение заголовка письма с указанным префиксом
+func headerLine(data, prefix string) string {
+	for _, line := range strings.Split(data, "\r\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return ""
+}
+
+func TestSMTPMailerStripsHeaderInjection(t *testing.T) {
+	server := startFakeSMTP(t)
+	m := NewSMTPMailer(Config{Host: "127.0.0.1", Port: server.port(), From: "noreply@swirl.test"})
+
+	if err := m.Send(Message{
+		To:      "vasya@example.com",
+		Subject: "Hello\r\nBcc: victim@example.com",
+		Body:    "body",
+	}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+
+	if server.auth != "" {
+		t.Errorf("AUTH sent without credentials")
+	}
+	if strings.Contains(server.data, "\r\nBcc:") {
+		t.Errorf("subject injected a header:\n%s", server.data)
+	}
+}
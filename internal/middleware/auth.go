@@ -4,11 +4,14 @@ import (
 	"net/http"
 	"strings"
 
+	"swirl-backend/internal/models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+func AuthMiddleware(jwtSecret string, db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -48,7 +51,47 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		// Проверяем, что сессия токена не отозвана
+		sessionID, _ := claims["session_id"].(string)
+		session, err := ActiveSession(db, sessionID, userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			c.Abort()
+			return
+		}
+
+		if session.User.IsBlocked() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is blocked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", userID)
+		c.Set("session_id", sessionID)
+		c.Set("is_guest", session.User.IsGuest)
+		c.Set("role", string(session.User.Role))
 		c.Next()
 	}
 }
+
+// ActiveSession загружает активную сессию пользователя вместе с самим пользователем
+func ActiveSession(db *gorm.DB, sessionID, userID string) (*models.Session, error) {
+	if sessionID == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var session models.Session
+	if err := db.Preload("User").Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+// SessionActive проверяет, что сессия существует, принадлежит пользователю и активна
+func SessionActive(db *gorm.DB, sessionID, userID string) bool {
+	_, err := ActiveSession(db, sessionID, userID)
+	return err == nil
+}
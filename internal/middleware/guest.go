@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// guestScope чем ограничен маршрут, открытый гостю
+type guestScope int
+
+const (
+	guestAnywhere        guestScope = iota // Без ограничений
+	guestRouletteChat                      // :id - чат чатрулетки
+	guestRouletteMessage                   // :id - сообщение в чате чатрулетки
+)
+
+// guestRoutes маршруты, доступные гостю: метод и полный шаблон пути.
+// Новые маршруты гостю закрыты, пока их не добавят сюда явно
+var guestRoutes = map[string]guestScope{
+	"GET /api/v1/profile":          guestAnywhere,
+	"POST /api/v1/profile/online":  guestAnywhere,
+	"POST /api/v1/profile/upgrade": guestAnywhere,
+	"GET /api/v1/stickers":         guestAnywhere,
+
+	"GET /api/v1/swirl/find":      guestAnywhere,
+	"POST /api/v1/swirl/:id/save": guestAnywhere,
+	"POST /api/v1/swirl/:id/skip": guestAnywhere,
+	"POST /api/v1/swirl/activity": guestAnywhere,
+	"GET /api/v1/swirl/status":    guestAnywhere,
+
+	"GET /api/v1/chats/:id/messages":    guestRouletteChat,
+	"POST /api/v1/chats/:id/messages":   guestRouletteChat,
+	"PUT /api/v1/chats/:id/message-ttl": guestRouletteChat,
+
+	"DELETE /api/v1/messages/:id":                  guestRouletteMessage,
+	"PUT /api/v1/messages/:id/read":                guestRouletteMessage,
+	"PUT /api/v1/messages/:id/edit":                guestRouletteMessage,
+	"GET /api/v1/messages/:id/status":              guestRouletteMessage,
+	"GET /api/v1/messages/:id/history":             guestRouletteMessage,
+	"GET /api/v1/messages/:id/reactions":           guestRouletteMessage,
+	"POST /api/v1/messages/:id/reactions":          guestRouletteMessage,
+	"DELETE /api/v1/messages/:id/reactions/:emoji": guestRouletteMessage,
+	"POST /api/v1/messages/:id/like":               guestRouletteMessage,
+	"DELETE /api/v1/messages/:id/like":             guestRouletteMessage,
+	"GET /api/v1/messages/:id/likes":               guestRouletteMessage,
+	"POST /api/v1/messages/:id/poll/vote":          guestRouletteMessage,
+	"GET /api/v1/messages/:id/poll/voters":         guestRouletteMessage,
+}
+
+// GuestAccess ограничивает гостевые аккаунты: им доступны только чатрулетка,
+// сообщения и исчезающие сообщения в чатах чатрулетки и свой профиль. Должен стоять после AuthMiddleware
+func GuestAccess(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("is_guest") {
+			c.Next()
+			return
+		}
+
+		allowed := false
+		if scope, ok := guestRoutes[c.Request.Method+" "+c.FullPath()]; ok {
+			switch scope {
+			case guestAnywhere:
+				allowed = true
+			case guestRouletteChat:
+				allowed = RouletteChat(db, c.Param("id"))
+			case guestRouletteMessage:
+				var message models.Message
+				if err := db.Select("chat_id").Where("id = ?", c.Param("id")).First(&message).Error; err == nil {
+					allowed = RouletteChat(db, message.ChatID.String())
+				}
+			}
+		}
+
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not available for guest accounts"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RouletteChat проверяет, что чат создан чатрулеткой
+func RouletteChat(db *gorm.DB, chatID string) bool {
+	var count int64
+	db.Model(&models.Chat{}).Where("id = ? AND is_roulette = ?", chatID, true).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyHeader      = "Idempotency-Key"
+	idempotentReplayHeader = "Idempotent-Replayed" // Выставляется в ответе-повторе
+	maxIdempotencyKey      = 255
+	maxIdempotentBody      = 1 << 20         // Запросы с телом больше 1 МБ по ключу не запоминаются
+	idempotencyLease       = 5 * time.Minute // Через сколько незавершенный запрос считается брошенным
+)
+
+// responseRecorder копирует тело ответа, чтобы сохранить его для повторов
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency делает POST-запросы с заголовком Idempotency-Key повторяемыми: первый
+// запрос выполняется, а его ответ сохраняется; повтор с тем же ключом получает сохраненный
+// ответ без повторного выполнения. Ключ принадлежит пользователю, поэтому middleware
+// должен стоять после AuthMiddleware. Ответы 5xx не сохраняются - такой запрос можно повторить.
+// Выполняемый запрос держит ключ до locked_until: если процесс упал, не дописав ответ,
+// после истечения срока повтор забирает ключ и выполняет запрос заново
+func Idempotency(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotentBody+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		if len(body) > maxIdempotentBody {
+			c.Next()
+			return
+		}
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+
+		// Срок аренды служит и ее меткой: завершить или освободить ключ может только тот, кто его взял.
+		// Postgres хранит время с точностью до микросекунд
+		lockedUntil := time.Now().Add(idempotencyLease).Truncate(time.Microsecond)
+		record := models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			LockedUntil: &lockedUntil,
+		}
+		claimed, err := claimIdempotencyKey(db, &record)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+		if !claimed {
+			replayIdempotent(c, db, &record)
+			return
+		}
+
+		// Первый запрос с этим ключом: выполняем и запоминаем ответ.
+		// Если обработчик упал, ключ освобождается, чтобы запрос можно было повторить
+		owned := db.Model(&models.IdempotencyKey{}).
+			Where("user_id = ? AND key = ? AND locked_until = ?", userID, key, lockedUntil).
+			Session(&gorm.Session{})
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			if !completed {
+				owned.Delete(&models.IdempotencyKey{})
+			}
+		}()
+
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		now := time.Now()
+		if err := owned.Updates(map[string]interface{}{
+			"status_code":  status,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"response":     recorder.body.Bytes(),
+			"completed_at": now,
+		}).Error; err != nil {
+			return
+		}
+		completed = true
+	}
+}
+
+// claimIdempotencyKey создает запись о запросе или забирает запись, брошенную незавершенной:
+// срок аренды истек (или не был выставлен), а запрос тот же. false - ключ занят или уже использован
+func claimIdempotencyKey(db *gorm.DB, record *models.IdempotencyKey) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error == nil, result.Error
+	}
+
+	result = db.Model(&models.IdempotencyKey{}).
+		Where("user_id = ? AND key = ? AND request_hash = ?", record.UserID, record.Key, record.RequestHash).
+		Where("completed_at IS NULL AND (locked_until IS NULL OR locked_until < ?)", time.Now()).
+		Updates(map[string]interface{}{
+			"locked_until": record.LockedUntil,
+			"created_at":   time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// replayIdempotent отвечает на повтор запроса с уже использованным ключом
+func replayIdempotent(c *gin.Context, db *gorm.DB, record *models.IdempotencyKey) {
+	var stored models.IdempotencyKey
+	err := db.Where("user_id = ? AND key = ?", record.UserID, record.Key).First(&stored).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// Первый запрос только что завершился ошибкой и освободил ключ
+		c.JSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key failed, retry"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+	case stored.RequestHash != record.RequestHash:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was used with a different request"})
+	case stored.CompletedAt == nil:
+		c.JSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is still in progress"})
+	default:
+		c.Header(idempotentReplayHeader, "true")
+		c.Data(stored.StatusCode, stored.ContentType, stored.Response)
+	}
+	c.Abort()
+}
+
+// CleanupIdempotencyKeys удаляет ключи старше ttl
+func CleanupIdempotencyKeys(db *gorm.DB, ttl time.Duration) error {
+	return db.Where("created_at < ?", time.Now().Add(-ttl)).Delete(&models.IdempotencyKey{}).Error
+}
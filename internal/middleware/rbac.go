@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+
+	"swirl-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole пропускает только пользователей с одной из указанных ролей.
+// Должен стоять после AuthMiddleware
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := models.Role(c.GetString("role"))
+		for _, r := range roles {
+			if role == r {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+		c.Abort()
+	}
+}
+
+// RequirePermission пропускает только пользователей, чья роль дает указанное право.
+// Должен стоять после AuthMiddleware
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.Role(c.GetString("role")).Has(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
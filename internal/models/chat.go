@@ -1,6 +1,7 @@
 package models
 
 import (
+	"bytes"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,12 +16,70 @@ const (
 	ChatTypeSaved   ChatType = "saved" // Сохраненный чат из чатрулетки
 )
 
+// ChatRole роль участника в чате
+type ChatRole string
+
+const (
+	ChatRoleOwner  ChatRole = "owner"  // Владелец: все права, удаление чата, назначение админов
+	ChatRoleAdmin  ChatRole = "admin"  // Админ: добавление и удаление участников, переименование
+	ChatRoleMember ChatRole = "member" // Обычный участник
+)
+
+// ChatAction действие над чатом, требующее прав
+type ChatAction string
+
+const (
+	ChatActionAddMembers     ChatAction = "add_members"
+	ChatActionRemoveMembers  ChatAction = "remove_members"
+	ChatActionRename         ChatAction = "rename"
+	ChatActionManageRoles    ChatAction = "manage_roles"
+	ChatActionManageInvites  ChatAction = "manage_invites"  // Ссылки-приглашения и заявки на вступление
+	ChatActionDeleteMessages ChatAction = "delete_messages" // Удаление чужих сообщений у всех (только в группах)
+	ChatActionDelete         ChatAction = "delete"
+)
+
+// chatRolePermissions какие действия разрешены каждой роли
+var chatRolePermissions = map[ChatRole][]ChatAction{
+	ChatRoleOwner: {
+		ChatActionAddMembers, ChatActionRemoveMembers, ChatActionRename,
+		ChatActionManageRoles, ChatActionManageInvites, ChatActionDeleteMessages, ChatActionDelete,
+	},
+	ChatRoleAdmin: {
+		ChatActionAddMembers, ChatActionRemoveMembers, ChatActionRename,
+		ChatActionManageInvites, ChatActionDeleteMessages,
+	},
+	ChatRoleMember: {},
+}
+
+// Can проверяет, разрешено ли роли действие
+func (r ChatRole) Can(action ChatAction) bool {
+	for _, a := range chatRolePermissions[r] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks проверяет, что роль старше другой (админ не может удалить владельца или другого админа)
+func (r ChatRole) Outranks(other ChatRole) bool {
+	rank := map[ChatRole]int{ChatRoleMember: 0, ChatRoleAdmin: 1, ChatRoleOwner: 2}
+	return rank[r] > rank[other]
+}
+
 type Chat struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name        string    `json:"name" gorm:"not null"`
 	Type        ChatType  `json:"type" gorm:"not null"`
 	Description string    `json:"description"`
 	CreatedBy   uuid.UUID `json:"created_by" gorm:"type:uuid;not null"`
+	IsRoulette  bool      `json:"is_roulette" gorm:"default:false"` // Чат создан чатрулеткой
+	MessageTTL  int       `json:"message_ttl" gorm:"not null;default:0"` // Исчезающие сообщения: время жизни в секундах, 0 - выключено
+
+	// Упорядоченная пара участников личного чата: уникальна, чтобы между двумя
+	// пользователями был только один личный чат (у чатов чатрулетки не заполнена)
+	DirectUserLow  *uuid.UUID `json:"-" gorm:"type:uuid;uniqueIndex:idx_chats_direct_pair"`
+	DirectUserHigh *uuid.UUID `json:"-" gorm:"type:uuid;uniqueIndex:idx_chats_direct_pair"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
@@ -28,6 +87,13 @@ type Chat struct {
 	CreatedByUser User           `json:"created_by_user" gorm:"foreignKey:CreatedBy"`
 	Participants  []ChatUser     `json:"participants" gorm:"foreignKey:ChatID"`
 	Messages      []Message      `json:"messages" gorm:"foreignKey:ChatID"`
+
+	// Поля списка чатов, вычисляются для текущего пользователя и в БД не хранятся
+	LastMessage        *Message   `json:"last_message,omitempty" gorm:"-"`
+	LastActivityAt     *time.Time `json:"last_activity_at,omitempty" gorm:"-"`
+	UnreadCount        *int64     `json:"unread_count,omitempty" gorm:"-"`
+	UnreadMentionCount *int64     `json:"unread_mention_count,omitempty" gorm:"-"`
+	MutedUntil         *time.Time `json:"muted_until,omitempty" gorm:"-"`
 }
 
 type ChatUser struct {
@@ -36,12 +102,40 @@ type ChatUser struct {
 	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
 	JoinedAt  time.Time `json:"joined_at"`
 	IsActive  bool      `json:"is_active" gorm:"default:true"`
+	Role      ChatRole  `json:"role" gorm:"not null;default:'member'"`
+	AddedBy   *uuid.UUID `json:"added_by,omitempty" gorm:"type:uuid"`
+
+	// Курсор прочтения: все сообщения до (last_read_at, last_read_message_id) включительно прочитаны
+	LastReadMessageID *uuid.UUID `json:"last_read_message_id,omitempty" gorm:"type:uuid"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
+
+	// Уведомления о новых сообщениях выключены до этого времени; упоминания приходят всегда
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
 
 	// Связи
 	Chat Chat `json:"chat" gorm:"foreignKey:ChatID"`
 	User User `json:"user" gorm:"foreignKey:UserID"`
 }
 
+// HasRead проверяет, покрывает ли курсор прочтения участника сообщение
+func (cu *ChatUser) HasRead(m *Message) bool {
+	if cu.LastReadAt == nil || cu.LastReadMessageID == nil {
+		return false
+	}
+	if !m.CreatedAt.Equal(*cu.LastReadAt) {
+		return m.CreatedAt.Before(*cu.LastReadAt)
+	}
+	return bytes.Compare(m.ID[:], cu.LastReadMessageID[:]) <= 0
+}
+
+// DirectPair возвращает пару пользователей в каноническом порядке (как сравнивает Postgres)
+func DirectPair(a, b uuid.UUID) (low, high uuid.UUID) {
+	if bytes.Compare(a[:], b[:]) < 0 {
+		return a, b
+	}
+	return b, a
+}
+
 // BeforeCreate хук для GORM
 func (c *Chat) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == uuid.Nil {
@@ -54,5 +148,8 @@ func (cu *ChatUser) BeforeCreate(tx *gorm.DB) error {
 	if cu.ID == uuid.Nil {
 		cu.ID = uuid.New()
 	}
+	if cu.JoinedAt.IsZero() {
+		cu.JoinedAt = time.Now()
+	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChatInvite ссылка-приглашение в групповой чат
+type ChatInvite struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ChatID           uuid.UUID  `json:"chat_id" gorm:"type:uuid;not null;index"`
+	Code             string     `json:"code" gorm:"not null;uniqueIndex"`
+	CreatedBy        uuid.UUID  `json:"created_by" gorm:"type:uuid;not null"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	MaxUses          int        `json:"max_uses" gorm:"default:0"` // 0 - без ограничений
+	UseCount         int        `json:"use_count" gorm:"default:0"`
+	RequiresApproval bool       `json:"requires_approval" gorm:"default:false"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+
+	// Связи
+	Chat          Chat `json:"-" gorm:"foreignKey:ChatID"`
+	CreatedByUser User `json:"-" gorm:"foreignKey:CreatedBy"`
+}
+
+type JoinRequestStatus string
+
+const (
+	JoinRequestPending  JoinRequestStatus = "pending"
+	JoinRequestApproved JoinRequestStatus = "approved"
+	JoinRequestRejected JoinRequestStatus = "rejected"
+)
+
+// ChatJoinRequest заявка на вступление по ссылке, требующей одобрения
+type ChatJoinRequest struct {
+	ID         uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ChatID     uuid.UUID         `json:"chat_id" gorm:"type:uuid;not null;index"`
+	InviteID   uuid.UUID         `json:"invite_id" gorm:"type:uuid;not null"`
+	UserID     uuid.UUID         `json:"user_id" gorm:"type:uuid;not null"`
+	Status     JoinRequestStatus `json:"status" gorm:"not null;default:'pending'"`
+	ReviewedBy *uuid.UUID        `json:"reviewed_by,omitempty" gorm:"type:uuid"`
+	ReviewedAt *time.Time        `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+
+	// Связи
+	User   User       `json:"user" gorm:"foreignKey:UserID"`
+	Invite ChatInvite `json:"-" gorm:"foreignKey:InviteID"`
+}
+
+// BeforeCreate хук для GORM
+func (i *ChatInvite) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	if i.Code == "" {
+		code, err := NewInviteCode()
+		if err != nil {
+			return err
+		}
+		i.Code = code
+	}
+	return nil
+}
+
+func (r *ChatJoinRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsUsable проверяет, что по ссылке еще можно вступить
+func (i *ChatInvite) IsUsable() bool {
+	if i.RevokedAt != nil {
+		return false
+	}
+	if i.ExpiresAt != nil && time.Now().After(*i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == 0 || i.UseCount < i.MaxUses
+}
+
+// NewInviteCode генерирует короткий код для ссылки-приглашения
+func NewInviteCode() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
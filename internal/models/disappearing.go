@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Допустимое время жизни исчезающих сообщений, в секундах
+const (
+	MinMessageTTL = 5
+	MaxMessageTTL = 4 * 7 * 24 * 60 * 60 // 4 недели
+)
+
+// ValidMessageTTL проверяет время жизни сообщений (0 - не исчезают)
+func ValidMessageTTL(ttl int) bool {
+	return ttl == 0 || (ttl >= MinMessageTTL && ttl <= MaxMessageTTL)
+}
+
+// MessageExpiry считает, когда исчезнет сообщение, отправленное в from.
+// Если заданы и TTL чата, и TTL сообщения, действует меньший; nil - сообщение не исчезает
+func MessageExpiry(from time.Time, chatTTL, messageTTL int) *time.Time {
+	ttl := chatTTL
+	if messageTTL > 0 && (ttl == 0 || messageTTL < ttl) {
+		ttl = messageTTL
+	}
+	if ttl == 0 {
+		return nil
+	}
+	expiresAt := from.Add(time.Duration(ttl) * time.Second)
+	return &expiresAt
+}
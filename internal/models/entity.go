@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// EntityType вид форматирования фрагмента текста
+type EntityType string
+
+const (
+	EntityBold          EntityType = "bold"
+	EntityItalic        EntityType = "italic"
+	EntityUnderline     EntityType = "underline"
+	EntityStrikethrough EntityType = "strikethrough"
+	EntitySpoiler       EntityType = "spoiler"
+	EntityCode          EntityType = "code"      // Моноширинный фрагмент внутри строки
+	EntityPre           EntityType = "pre"       // Блок кода
+	EntityTextLink      EntityType = "text_link" // Текст-ссылка, адрес в URL
+	EntityURL           EntityType = "url"       // Адрес прямо в тексте, находится сервером
+)
+
+// MessageEntity фрагмент текста сообщения с форматированием.
+// Offset и Length считаются в единицах UTF-16, как в клиентах
+type MessageEntity struct {
+	Type   EntityType `json:"type"`
+	Offset int        `json:"offset"`
+	Length int        `json:"length"`
+	URL    string     `json:"url,omitempty"` // Только для text_link
+}
+
+// IsKnown проверяет, что вид форматирования поддерживается
+func (t EntityType) IsKnown() bool {
+	switch t {
+	case EntityBold, EntityItalic, EntityUnderline, EntityStrikethrough, EntitySpoiler,
+		EntityCode, EntityPre, EntityTextLink, EntityURL:
+		return true
+	}
+	return false
+}
+
+// IsCode проверяет, что фрагмент - код: внутри него нет другого форматирования и упоминаний
+func (t EntityType) IsCode() bool {
+	return t == EntityCode || t == EntityPre
+}
+
+// End позиция сразу за фрагментом
+func (e *MessageEntity) End() int {
+	return e.Offset + e.Length
+}
+
+// MessageEntities форматирование сообщения, хранится в jsonb; пустое - NULL
+type MessageEntities []MessageEntity
+
+// Value реализует driver.Valuer
+func (e MessageEntities) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan реализует sql.Scanner
+func (e *MessageEntities) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	}
+	return fmt.Errorf("unsupported entities value %T", value)
+}
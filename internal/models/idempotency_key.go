@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey сохраненный ответ на запрос с заголовком Idempotency-Key.
+// Повтор запроса с тем же ключом получает этот ответ вместо повторного выполнения
+type IdempotencyKey struct {
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Key         string     `json:"key" gorm:"primaryKey;size:255"`
+	Method      string     `json:"method" gorm:"not null"`
+	Path        string     `json:"path" gorm:"not null"`
+	RequestHash string     `json:"-" gorm:"not null"` // SHA-256 метода, пути и тела запроса
+	StatusCode  int        `json:"status_code"`       // 0 - запрос еще выполняется
+	ContentType string     `json:"-"`
+	Response    []byte     `json:"-"`
+	LockedUntil *time.Time `json:"-"` // Пока не истекло, запрос выполняется; после - незавершенный запрос можно выполнить заново
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LinkPreview карточка первой ссылки сообщения (Open Graph / Twitter Card).
+// Сервер заполняет ее после отправки, клиент получает событие message_preview_ready
+type LinkPreview struct {
+	URL         string `json:"url"`
+	SiteName    string `json:"site_name,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// Value реализует driver.Valuer
+func (p *LinkPreview) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan реализует sql.Scanner
+func (p *LinkPreview) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*p = LinkPreview{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	}
+	return fmt.Errorf("unsupported link preview value %T", value)
+}
+
+// LinkPreviewCache загруженные превью по адресу, чтобы одну ссылку не скачивать для каждого сообщения.
+// Пустое Preview - страницу загрузить не удалось или карточки на ней нет; такие записи живут меньше
+type LinkPreviewCache struct {
+	URL       string       `gorm:"primaryKey;size:2048"`
+	Preview   *LinkPreview `gorm:"type:jsonb"`
+	FetchedAt time.Time    `gorm:"not null;index"`
+}
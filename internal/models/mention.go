@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageMention упоминание участника чата (@username) в тексте сообщения.
+// Offset и Length - позиция упоминания вместе с @ в единицах UTF-16, как считают клиенты
+type MessageMention struct {
+	MessageID uuid.UUID  `json:"-" gorm:"type:uuid;primaryKey"`
+	Offset    int        `json:"offset" gorm:"primaryKey;autoIncrement:false"`
+	Length    int        `json:"length" gorm:"not null"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	ReadAt    *time.Time `json:"-"` // Когда упомянутый дошел до сообщения; пусто - упоминание непрочитано
+	CreatedAt time.Time  `json:"-"`
+}
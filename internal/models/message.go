@@ -17,6 +17,8 @@ const (
 	MessageTypeVideo    MessageType = "video"
 	MessageTypeImage    MessageType = "image"
 	MessageTypeReply    MessageType = "reply"
+	MessageTypePoll     MessageType = "poll"   // Опрос: вопрос в content, варианты в poll
+	MessageTypeSystem   MessageType = "system" // Служебные сообщения ("X добавил Y"), создаются только сервером
 )
 
 type MessageStatus string
@@ -24,7 +26,7 @@ type MessageStatus string
 const (
 	MessageStatusSent     MessageStatus = "sent"     // Отправлено
 	MessageStatusDelivered MessageStatus = "delivered" // Доставлено
-	MessageStatusRead     MessageStatus = "read"     // Прочитано
+	MessageStatusRead     MessageStatus = "read"     // Прочитано (вычисляется по курсорам прочтения, не хранится)
 	MessageStatusEdited   MessageStatus = "edited"   // Изменено
 	MessageStatusDeleted  MessageStatus = "deleted"  // Удалено
 )
@@ -37,19 +39,55 @@ type Message struct {
 	Content   string        `json:"content" gorm:"not null"`
 	MediaURL  string        `json:"media_url,omitempty"`
 	ReplyToID *uuid.UUID    `json:"reply_to_id,omitempty" gorm:"type:uuid"`
+
+	// Идентификатор, который клиент присвоил сообщению: уникален для отправителя,
+	// повторная отправка с тем же значением возвращает уже созданное сообщение
+	ClientMessageID *string `json:"client_message_id,omitempty" gorm:"size:64"`
+
+	// Стикер из каталога: media_url и content (эмодзи) копируются из него при отправке
+	StickerID *uuid.UUID `json:"sticker_id,omitempty" gorm:"type:uuid"`
+
+	// Треды: ответы ссылаются на корневое сообщение, у корня хранится сводка
+	ThreadRootID      *uuid.UUID `json:"thread_root_id,omitempty" gorm:"type:uuid;index"`
+	ThreadReplyCount  int        `json:"thread_reply_count" gorm:"default:0"`
+	ThreadLastReplyID *uuid.UUID `json:"thread_last_reply_id,omitempty" gorm:"type:uuid"`
+	ThreadLastReplyAt *time.Time `json:"thread_last_reply_at,omitempty"`
+
+	// Пересылка: откуда сообщение переслано. Автор указывается, только если он
+	// не скрывает имя (show_username), чат - только если это группа
+	ForwardedFromMessageID *uuid.UUID `json:"forwarded_from_message_id,omitempty" gorm:"type:uuid"`
+	ForwardedFromUserID    *uuid.UUID `json:"forwarded_from_user_id,omitempty" gorm:"type:uuid"`
+	ForwardedFromName      string     `json:"forwarded_from_name,omitempty"`
+	ForwardedFromChatID    *uuid.UUID `json:"forwarded_from_chat_id,omitempty" gorm:"type:uuid"`
+	ForwardedFromChatName  string     `json:"forwarded_from_chat_name,omitempty"`
 	
 	// Статус сообщения
 	Status      MessageStatus `json:"status" gorm:"default:'sent'"`
 	IsEdited    bool          `json:"is_edited" gorm:"default:false"`
 	EditedAt    *time.Time    `json:"edited_at,omitempty"`
-	ReadAt      *time.Time    `json:"read_at,omitempty"`
-	ReadBy      []uuid.UUID   `json:"read_by,omitempty" gorm:"type:uuid[]"` // Кто прочитал сообщение
-	
-	// Лайки
-	LikesCount int         `json:"likes_count" gorm:"default:0"` // Количество лайков
-	LikedBy    []uuid.UUID `json:"liked_by,omitempty" gorm:"type:uuid[]"` // Кто лайкнул
-	LikedAt    *time.Time  `json:"liked_at,omitempty"` // Время последнего лайка
+
+	// Удаление у всех: сообщение остается "надгробием" без содержимого
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *uuid.UUID `json:"deleted_by,omitempty" gorm:"type:uuid"` // Пусто, если сообщение исчезло по таймеру
+
+	// Исчезающее сообщение: после этого времени становится "надгробием"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	
+	// Реакции по эмодзи, считаются из message_reactions при выдаче
+	Reactions []ReactionCount `json:"reactions" gorm:"-"`
+
+	// Форматирование текста (жирный, код, ссылки...), позиции в единицах UTF-16
+	Entities MessageEntities `json:"entities,omitempty" gorm:"type:jsonb"`
+
+	// Упоминания участников в тексте, находятся сервером при отправке и правке
+	Mentions []MessageMention `json:"mentions,omitempty" gorm:"foreignKey:MessageID"`
+
+	// Превью первой ссылки, появляется асинхронно после отправки
+	LinkPreview *LinkPreview `json:"link_preview,omitempty" gorm:"type:jsonb"`
+
+	// Опрос с итогами голосования, только у сообщений типа poll
+	Poll *Poll `json:"poll,omitempty" gorm:"foreignKey:MessageID"`
+
 	CreatedAt time.Time   `json:"created_at"`
 	UpdatedAt time.Time   `json:"updated_at"`
 
@@ -71,32 +109,28 @@ func (m *Message) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// MarkAsDelivered отмечает сообщение как доставленное
-func (m *Message) MarkAsDelivered() {
-	m.Status = MessageStatusDelivered
+// IsEditable проверяет, можно ли редактировать сообщения этого типа:
+// текст и ответы целиком, фото и видео - подпись и файл того же типа
+func (t MessageType) IsEditable() bool {
+	switch t {
+	case MessageTypeText, MessageTypeReply, MessageTypeImage, MessageTypeVideo:
+		return true
+	}
+	return false
 }
 
-// MarkAsRead отмечает сообщение как прочитанное пользователем
-func (m *Message) MarkAsRead(userID uuid.UUID) {
-	// Проверяем, не прочитал ли уже этот пользователь
-	for _, id := range m.ReadBy {
-		if id == userID {
-			return // Уже прочитано этим пользователем
-		}
-	}
-	
-	// Добавляем пользователя в список прочитавших
-	m.ReadBy = append(m.ReadBy, userID)
-	
-	// Если это первое прочтение, обновляем время
-	if m.ReadAt == nil {
-		now := time.Now()
-		m.ReadAt = &now
+// HasMedia проверяет, что сообщение этого типа содержит файл
+func (t MessageType) HasMedia() bool {
+	switch t {
+	case MessageTypeImage, MessageTypeVideo, MessageTypeVoice, MessageTypeGif, MessageTypeSticker:
+		return true
 	}
-	
-	// Если все участники чата прочитали, меняем статус
-	// TODO: Нужно получить список участников чата для полной проверки
-	m.Status = MessageStatusRead
+	return false
+}
+
+// MarkAsDelivered отмечает сообщение как доставленное
+func (m *Message) MarkAsDelivered() {
+	m.Status = MessageStatusDelivered
 }
 
 // MarkAsEdited отмечает сообщение как измененное
@@ -107,9 +141,34 @@ func (m *Message) MarkAsEdited() {
 	m.EditedAt = &now
 }
 
-// MarkAsDeleted отмечает сообщение как удаленное
-func (m *Message) MarkAsDeleted() {
+// MarkAsDeleted превращает сообщение в "надгробие": ID и время остаются, содержимое стирается
+func (m *Message) MarkAsDeleted(by uuid.UUID) {
+	m.MarkAsExpired()
+	m.DeletedBy = &by
+}
+
+// MarkAsExpired превращает исчезающее сообщение в "надгробие" без указания, кто удалил
+func (m *Message) MarkAsExpired() {
+	now := time.Now()
 	m.Status = MessageStatusDeleted
+	m.Content = ""
+	m.Entities = nil
+	m.LinkPreview = nil
+	m.Poll = nil
+	m.MediaURL = ""
+	m.StickerID = nil
+	m.ForwardedFromMessageID = nil
+	m.ForwardedFromUserID = nil
+	m.ForwardedFromName = ""
+	m.ForwardedFromChatID = nil
+	m.ForwardedFromChatName = ""
+	m.DeletedAt = &now
+	m.DeletedBy = nil
+}
+
+// IsDeleted проверяет, удалено ли сообщение у всех
+func (m *Message) IsDeleted() bool {
+	return m.Status == MessageStatusDeleted
 }
 
 // GetStatusText возвращает текстовое описание статуса
@@ -129,74 +188,3 @@ func (m *Message) GetStatusText() string {
 		return "Неизвестно"
 	}
 }
-
-// IsReadBy проверяет, прочитал ли сообщение конкретный пользователь
-func (m *Message) IsReadBy(userID uuid.UUID) bool {
-	for _, id := range m.ReadBy {
-		if id == userID {
-			return true
-		}
-	}
-	return false
-}
-
-// LikeMessage добавляет лайк от пользователя
-func (m *Message) LikeMessage(userID uuid.UUID) bool {
-	// Проверяем, не лайкнул ли уже этот пользователь
-	for _, id := range m.LikedBy {
-		if id == userID {
-			return false // Уже лайкнул
-		}
-	}
-	
-	// Добавляем лайк
-	m.LikedBy = append(m.LikedBy, userID)
-	m.LikesCount++
-	now := time.Now()
-	m.LikedAt = &now
-	
-	return true
-}
-
-// UnlikeMessage убирает лайк от пользователя
-func (m *Message) UnlikeMessage(userID uuid.UUID) bool {
-	// Ищем пользователя в списке лайкнувших
-	for i, id := range m.LikedBy {
-		if id == userID {
-			// Удаляем из списка
-			m.LikedBy = append(m.LikedBy[:i], m.LikedBy[i+1:]...)
-			m.LikesCount--
-			
-			// Обновляем время последнего лайка
-			if len(m.LikedBy) > 0 {
-				now := time.Now()
-				m.LikedAt = &now
-			} else {
-				m.LikedAt = nil
-			}
-			
-			return true
-		}
-	}
-	
-	return false // Не лайкал
-}
-
-// IsLikedBy проверяет, лайкнул ли сообщение конкретный пользователь
-func (m *Message) IsLikedBy(userID uuid.UUID) bool {
-	for _, id := range m.LikedBy {
-		if id == userID {
-			return true
-		}
-	}
-	return false
-}
-
-// GetLikesInfo возвращает информацию о лайках
-func (m *Message) GetLikesInfo() map[string]interface{} {
-	return map[string]interface{}{
-		"likes_count": m.LikesCount,
-		"liked_by":    m.LikedBy,
-		"liked_at":    m.LikedAt,
-	}
-}
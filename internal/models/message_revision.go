@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MessageRevision прежняя версия сообщения, сохраняется при каждом редактировании
+type MessageRevision struct {
+	ID        uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	MessageID uuid.UUID       `json:"message_id" gorm:"type:uuid;not null;index"`
+	Content   string          `json:"content" gorm:"not null"`
+	Entities  MessageEntities `json:"entities,omitempty" gorm:"type:jsonb"`
+	MediaURL  string          `json:"media_url,omitempty"`
+	EditedBy  uuid.UUID       `json:"edited_by" gorm:"type:uuid;not null"`
+	CreatedAt time.Time       `json:"created_at"` // Когда эта версия была заменена
+}
+
+// BeforeCreate хук для GORM
+func (r *MessageRevision) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Poll опрос в сообщении типа poll; вопрос - текст сообщения
+type Poll struct {
+	MessageID      uuid.UUID    `json:"-" gorm:"type:uuid;primaryKey"`
+	Anonymous      bool         `json:"anonymous" gorm:"not null"`       // Кто как голосовал, не видно никому
+	MultipleChoice bool         `json:"multiple_choice" gorm:"not null"` // Можно выбрать несколько вариантов
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`             // После этого времени голосовать нельзя
+	Options        []PollOption `json:"options" gorm:"foreignKey:MessageID"`
+	CreatedAt      time.Time    `json:"-"`
+
+	// Итоги, считаются из poll_votes при выдаче
+	TotalVoters int64 `json:"total_voters" gorm:"-"`
+	Closed      bool  `json:"closed" gorm:"-"`
+	Chosen      []int `json:"chosen,omitempty" gorm:"-"` // Варианты, выбранные текущим пользователем
+}
+
+// PollOption вариант ответа; ID - номер варианта в опросе, с нуля
+type PollOption struct {
+	MessageID uuid.UUID `json:"-" gorm:"type:uuid;primaryKey"`
+	ID        int       `json:"id" gorm:"primaryKey;autoIncrement:false"`
+	Text      string    `json:"text" gorm:"not null"`
+	Voters    int64     `json:"voters" gorm:"-"`
+}
+
+// PollVote голос пользователя за вариант. При выборе нескольких вариантов - по строке на вариант
+type PollVote struct {
+	MessageID uuid.UUID `json:"-" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey;index"`
+	OptionID  int       `json:"option_id" gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Связи
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// IsClosed проверяет, закончилось ли голосование
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
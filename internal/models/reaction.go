@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReactionHeart - реакция, в которую перенесены старые лайки
+const ReactionHeart = "❤️"
+
+// AllowedReactions - эмодзи, которыми можно реагировать на сообщения
+var AllowedReactions = []string{ReactionHeart, "👍", "👎", "😂", "😮", "😢", "🔥", "🎉", "🙏", "👏"}
+
+// IsAllowedReaction проверяет, что эмодзи входит в разрешенный набор
+func IsAllowedReaction(emoji string) bool {
+	for _, allowed := range AllowedReactions {
+		if emoji == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageReaction реакция пользователя на сообщение; одним эмодзи пользователь реагирует один раз
+type MessageReaction struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	MessageID uuid.UUID `json:"message_id" gorm:"type:uuid;not null;uniqueIndex:idx_message_reactions_unique"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_message_reactions_unique;index"`
+	Emoji     string    `json:"emoji" gorm:"not null;uniqueIndex:idx_message_reactions_unique"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Связи
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// ReactionCount - количество реакций одним эмодзи в payload сообщения
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"` // Среди реакций есть реакция текущего пользователя
+}
+
+// BeforeCreate хук для GORM
+func (r *MessageReaction) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
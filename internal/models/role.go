@@ -0,0 +1,68 @@
+package models
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermissionQueueManage      Permission = "queue.manage"      // Управление очередью чатрулетки
+	PermissionUsersView        Permission = "users.view"        // Просмотр списка пользователей
+	PermissionUsersBlock       Permission = "users.block"       // Блокировка пользователей
+	PermissionUsersRoles       Permission = "users.roles"       // Назначение ролей
+	PermissionMessagesModerate Permission = "messages.moderate" // Удаление чужих сообщений
+	PermissionChatsModerate    Permission = "chats.moderate"    // Удаление чужих чатов
+	PermissionStickersManage   Permission = "stickers.manage"   // Каталог стикеров
+)
+
+// rolePermissions права, которые дает каждая роль
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {},
+	RoleModerator: {
+		PermissionQueueManage,
+		PermissionUsersView,
+		PermissionUsersBlock,
+		PermissionMessagesModerate,
+	},
+	RoleAdmin: {
+		PermissionQueueManage,
+		PermissionUsersView,
+		PermissionUsersBlock,
+		PermissionUsersRoles,
+		PermissionMessagesModerate,
+		PermissionChatsModerate,
+		PermissionStickersManage,
+	},
+}
+
+// IsValid проверяет, что роль существует
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions возвращает права роли
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Has проверяет, дает ли роль указанное право
+func (r Role) Has(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks проверяет, что роль старше другой (модератор не может блокировать админа и т.п.)
+func (r Role) Outranks(other Role) bool {
+	rank := map[Role]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+	return rank[r] > rank[other]
+}
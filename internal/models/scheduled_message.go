@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ScheduledStatus string
+
+const (
+	ScheduledPending   ScheduledStatus = "pending"   // Ждет времени отправки, можно менять и отменять
+	ScheduledSending   ScheduledStatus = "sending"   // Забрано воркером
+	ScheduledSent      ScheduledStatus = "sent"      // Отправлено, см. MessageID
+	ScheduledCancelled ScheduledStatus = "cancelled" // Отменено автором
+	ScheduledFailed    ScheduledStatus = "failed"    // Отправить не удалось, см. Error
+)
+
+// ScheduledMessage сообщение, которое будет отправлено в SendAt.
+// Отправленное сообщение получает тот же ID, поэтому повторная доставка не создаст дубль
+type ScheduledMessage struct {
+	ID              uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ChatID          uuid.UUID       `json:"chat_id" gorm:"type:uuid;not null;index"`
+	UserID          uuid.UUID       `json:"user_id" gorm:"type:uuid;not null;index"`
+	Type            MessageType     `json:"type" gorm:"not null"`
+	Content         string          `json:"content" gorm:"not null"`
+	Entities        MessageEntities `json:"entities,omitempty" gorm:"type:jsonb"`
+	MediaURL        string          `json:"media_url,omitempty"`
+	StickerID       *uuid.UUID      `json:"sticker_id,omitempty" gorm:"type:uuid"`
+	ReplyToID       *uuid.UUID      `json:"reply_to_id,omitempty" gorm:"type:uuid"`
+	ThreadRootID    *uuid.UUID      `json:"thread_root_id,omitempty" gorm:"type:uuid"`
+	SendAt          time.Time       `json:"send_at" gorm:"not null"`
+	TTL             int             `json:"ttl,omitempty" gorm:"not null;default:0"` // Время жизни после отправки, в секундах
+	ClientMessageID *string         `json:"client_message_id,omitempty" gorm:"size:64"`
+	Status          ScheduledStatus `json:"status" gorm:"not null;default:'pending'"`
+	MessageID       *uuid.UUID      `json:"message_id,omitempty" gorm:"type:uuid"`
+	Error           string          `json:"error,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// BeforeCreate хук для GORM
+func (s *ScheduledMessage) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	if s.Status == "" {
+		s.Status = ScheduledPending
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sticker стикер из общего каталога. Сообщение-стикер ссылается на него по ID
+type Sticker struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Pack      string    `json:"pack" gorm:"not null;index"`
+	Emoji     string    `json:"emoji" gorm:"not null"` // Подпись стикера в списке чатов и уведомлениях
+	MediaURL  string    `json:"media_url" gorm:"not null"`
+	CreatedBy uuid.UUID `json:"created_by" gorm:"type:uuid;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeforeCreate хук для GORM
+func (s *Sticker) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
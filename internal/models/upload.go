@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Upload загруженный пользователем файл. Сообщение может ссылаться только на свои загрузки
+type Upload struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	FileName    string    `json:"file_name" gorm:"not null"`
+	URL         string    `json:"url" gorm:"not null;uniqueIndex"` // /uploads/{user_id}/{file_name}, то же значение, что в media_url
+	ContentType string    `json:"content_type" gorm:"not null"`
+	MediaType   string    `json:"media_type" gorm:"not null"` // image, video или voice
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BeforeCreate хук для GORM
+func (u *Upload) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
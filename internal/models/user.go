@@ -13,6 +13,15 @@ type User struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Username  string    `json:"username" gorm:"uniqueIndex;not null"`
 	Password  string    `json:"-" gorm:"not null"`
+	Email     *string   `json:"-"` // Подтвержденный адрес, уникален без учета регистра; в JSON только через Account
+
+	EmailVerified bool `json:"-" gorm:"default:false"`
+	Passwordless  bool `json:"passwordless" gorm:"default:false"` // Пароль не задан (вход через внешний провайдер)
+	IsGuest       bool `json:"is_guest" gorm:"default:false;index"` // Гостевой аккаунт для чатрулетки
+
+	// Права доступа; в JSON только через Account и AdminView
+	Role      Role       `json:"-" gorm:"not null;default:'user'"`
+	BlockedAt *time.Time `json:"-"`
 	
 	// Профиль пользователя
 	Birthday        *time.Time `json:"birthday,omitempty" gorm:"type:date"`
@@ -29,6 +38,36 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// AccountView пользователь, каким его видит он сам: вместе с почтой и ролью.
+// Обычный User уходит другим участникам чатов, поэтому ни того, ни другого в нем нет
+type AccountView struct {
+	User
+	Email         *string `json:"email,omitempty"`
+	EmailVerified bool    `json:"email_verified"`
+	Role          Role    `json:"role"`
+}
+
+// AdminUserView пользователь в админке: данные аккаунта и блокировка
+type AdminUserView struct {
+	AccountView
+	BlockedAt *time.Time `json:"blocked_at,omitempty"`
+}
+
+// Account данные пользователя для него самого
+func (u *User) Account() AccountView {
+	return AccountView{
+		User:          *u,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Role:          u.Role,
+	}
+}
+
+// AdminView данные пользователя для модераторов и админов
+func (u *User) AdminView() AdminUserView {
+	return AdminUserView{AccountView: u.Account(), BlockedAt: u.BlockedAt}
+}
+
 // HashPassword хеширует пароль
 func (u *User) HashPassword(password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -52,6 +91,16 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// HasPermission проверяет, есть ли у пользователя право
+func (u *User) HasPermission(permission Permission) bool {
+	return u.Role.Has(permission)
+}
+
+// IsBlocked проверяет, заблокирован ли пользователь
+func (u *User) IsBlocked() bool {
+	return u.BlockedAt != nil
+}
+
 // UpdateOnlineStatus обновляет статус онлайн пользователя
 func (u *User) UpdateOnlineStatus(isOnline bool) {
 	u.IsOnline = isOnline
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserBlock пользователь заблокировал другого: тот не может писать ему в личные чаты и пересылать туда сообщения
+type UserBlock struct {
+	UserID        uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	BlockedUserID uuid.UUID `json:"blocked_user_id" gorm:"type:uuid;primaryKey;index"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	BlockedUser User `json:"blocked_user" gorm:"foreignKey:BlockedUserID"`
+}
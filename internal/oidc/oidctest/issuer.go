@@ -0,0 +1,181 @@
+// Package oidctest локальный OIDC провайдер для тестов: discovery, JWKS с ротацией ключей
+// и token endpoint, который проверяет PKCE так же, как настоящий
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClientID клиент, для которого провайдер выдает токены
+const ClientID = "swirl-client"
+
+// Issuer тестовый провайдер на httptest.Server
+type Issuer struct {
+	t      *testing.T
+	Server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	jwksHits   int
+	issuer     string
+	challenges map[string]string // code -> code_challenge
+	idTokens   map[string]string // code -> id_token
+}
+
+// NewIssuer запускает провайдер; сервер закрывается в конце теста
+func NewIssuer(t *testing.T) *Issuer {
+	t.Helper()
+	m := &Issuer{
+		t:          t,
+		keys:       map[string]*rsa.PrivateKey{},
+		challenges: map[string]string{},
+		idTokens:   map[string]string{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Server.Close)
+	m.issuer = m.Server.URL
+	return m
+}
+
+// URL адрес провайдера, он же issuer
+func (m *Issuer) URL() string {
+	return m.Server.URL
+}
+
+// SetAdvertisedIssuer подменяет issuer в discovery документе
+func (m *Issuer) SetAdvertisedIssuer(issuer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.issuer = issuer
+}
+
+// JWKSHits сколько раз загружали JWKS
+func (m *Issuer) JWKSHits() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksHits
+}
+
+// Rotate публикует новый ключ вместо всех прежних
+func (m *Issuer) Rotate(kid string) *rsa.PrivateKey {
+	m.t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatalf("generate key: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+// Authorize выдает код, как страница авторизации после входа пользователя.
+// Токен за этот код выдается только с code_verifier, подходящим к code_challenge из authURL
+func (m *Issuer) Authorize(authURL, idToken string) string {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("parse auth url: %v", err)
+	}
+	code := "code-" + u.Query().Get("state")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges[code] = u.Query().Get("code_challenge")
+	m.idTokens[code] = idToken
+	return code
+}
+
+// Claims корректные claims ID токена; тесты меняют нужные поля
+func (m *Issuer) Claims(subject, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   m.Server.URL,
+		"aud":   ClientID,
+		"sub":   subject,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+// Sign подписывает claims ключом RS256 с указанным kid
+func (m *Issuer) Sign(key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	m.t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		m.t.Fatalf("sign: %v", err)
+	}
+	return raw
+}
+
+func (m *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.issuer,
+		"authorization_endpoint": m.Server.URL + "/authorize",
+		"token_endpoint":         m.Server.URL + "/token",
+		"jwks_uri":               m.Server.URL + "/jwks",
+	})
+}
+
+func (m *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jwksHits++
+	keys := []map[string]string{}
+	for kid, key := range m.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (m *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ParseForm()
+	code := r.PostForm.Get("code")
+	challenge, ok := m.challenges[code]
+	if !ok || r.PostForm.Get("client_id") != ClientID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+	delete(m.challenges, code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     m.idTokens[code],
+	})
+}
@@ -0,0 +1,216 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"swirl-backend/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newProvider(issuer *oidctest.Issuer) *Provider {
+	return NewProvider(ProviderConfig{
+		Name:        "mock",
+		Issuer:      issuer.URL(),
+		ClientID:    oidctest.ClientID,
+		RedirectURL: "http://localhost:8080/api/v1/auth/oidc/mock/callback",
+	})
+}
+
+// claims корректные claims ID токена пользователя user-42
+func claims(issuer *oidctest.Issuer, nonce string) jwt.MapClaims {
+	c := issuer.Claims("user-42", nonce)
+	c["email"] = "vasya@example.com"
+	return c
+}
+
+func TestCodeChallengeRFC7636(t *testing.T) {
+	// Пример из RFC 7636, приложение B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	p := newProvider(issuer)
+
+	authURL, err := p.AuthCodeURL("state-1", "nonce-1", "challenge-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Scheme + "://" + u.Host + u.Path; got != issuer.URL()+"/authorize" {
+		t.Errorf("endpoint = %s", got)
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             oidctest.ClientID,
+		"redirect_uri":          "http://localhost:8080/api/v1/auth/oidc/mock/callback",
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        "challenge-1",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	issuer.SetAdvertisedIssuer("https://evil.example.com")
+
+	if _, err := newProvider(issuer).AuthCodeURL("s", "n", "c"); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Fatalf("AuthCodeURL error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestDiscoveryUnavailable(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	p := newProvider(issuer)
+	issuer.Server.Close()
+
+	if _, err := p.AuthCodeURL("s", "n", "c"); err == nil {
+		t.Fatal("AuthCodeURL succeeded without discovery")
+	}
+}
+
+func TestExchangeWithPKCE(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	key := issuer.Rotate("k1")
+	p := newProvider(issuer)
+
+	verifier, _ := RandomString()
+	authURL, err := p.AuthCodeURL("state-1", "nonce-1", CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := issuer.Authorize(authURL, issuer.Sign(key, "k1", claims(issuer, "nonce-1")))
+
+	got, err := p.Exchange(code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if got.Subject != "user-42" || got.Email != "vasya@example.com" {
+		t.Errorf("claims = %+v", got)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	key := issuer.Rotate("k1")
+	p := newProvider(issuer)
+
+	verifier, _ := RandomString()
+	authURL, _ := p.AuthCodeURL("state-1", "nonce-1", CodeChallenge(verifier))
+	code := issuer.Authorize(authURL, issuer.Sign(key, "k1", claims(issuer, "nonce-1")))
+
+	if _, err := p.Exchange(code, "another-verifier", "nonce-1"); err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Fatalf("Exchange error = %v, want PKCE failure", err)
+	}
+}
+
+func TestVerifyIDTokenChecks(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	key := issuer.Rotate("k1")
+	stranger, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		key    *rsa.PrivateKey
+		nonce  string
+		ok     bool
+	}{
+		{name: "valid", ok: true},
+		{name: "nonce mismatch", nonce: "other-nonce"},
+		{name: "wrong issuer", mutate: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", mutate: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "audience list with client", mutate: func(c jwt.MapClaims) { c["aud"] = []string{"another-client", oidctest.ClientID} }, ok: true},
+		{name: "expired", mutate: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no expiry", mutate: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "no subject", mutate: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "foreign signature", key: stranger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := claims(issuer, "nonce-1")
+			if tt.mutate != nil {
+				tt.mutate(c)
+			}
+			signer := key
+			if tt.key != nil {
+				signer = tt.key
+			}
+			nonce := "nonce-1"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err := newProvider(issuer).VerifyIDToken(issuer.Sign(signer, "k1", c), nonce)
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("VerifyIDToken accepted an invalid token")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsHMAC(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	issuer.Rotate("k1")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(issuer, "nonce-1"))
+	token.Header["kid"] = "k1"
+	raw, _ := token.SignedString([]byte("secret"))
+	if _, err := newProvider(issuer).VerifyIDToken(raw, "nonce-1"); err == nil {
+		t.Fatal("VerifyIDToken accepted an HS256 token")
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	oldKey := issuer.Rotate("k1")
+	p := newProvider(issuer)
+
+	if _, err := p.VerifyIDToken(issuer.Sign(oldKey, "k1", claims(issuer, "n")), "n"); err != nil {
+		t.Fatalf("verify with k1: %v", err)
+	}
+
+	// Провайдер сменил ключ; недавно загруженный набор не перезагружается на каждый неизвестный kid
+	newKey := issuer.Rotate("k2")
+	if _, err := p.VerifyIDToken(issuer.Sign(newKey, "k2", claims(issuer, "n")), "n"); err == nil {
+		t.Fatal("unknown kid accepted before refresh interval")
+	}
+	if issuer.JWKSHits() != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", issuer.JWKSHits())
+	}
+
+	// После интервала неизвестный kid перезагружает JWKS
+	p.mu.Lock()
+	p.keys.fetchedAt = time.Now().Add(-2 * jwksRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(issuer.Sign(newKey, "k2", claims(issuer, "n")), "n"); err != nil {
+		t.Fatalf("verify with rotated k2: %v", err)
+	}
+	if issuer.JWKSHits() != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", issuer.JWKSHits())
+	}
+
+	// Старый ключ больше не опубликован
+	if _, err := p.VerifyIDToken(issuer.Sign(oldKey, "k1", claims(issuer, "n")), "n"); err == nil {
+		t.Fatal("retired key k1 still accepted")
+	}
+}
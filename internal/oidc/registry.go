@@ -0,0 +1,32 @@
+package oidc
+
+import "sort"
+
+// Registry набор настроенных провайдеров по имени
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(configs []ProviderConfig) *Registry {
+	r := &Registry{providers: make(map[string]*Provider)}
+	for _, cfg := range configs {
+		r.providers[cfg.Name] = NewProvider(cfg)
+	}
+	return r
+}
+
+// Get возвращает провайдера по имени
+func (r *Registry) Get(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names возвращает имена всех провайдеров в алфавитном порядке
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
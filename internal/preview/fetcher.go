@@ -0,0 +1,301 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	defaultTimeout     = 5 * time.Second
+	defaultMaxBodySize = 1 << 20 // Разбирается только начало страницы: метатеги лежат в <head>
+	maxRedirects       = 5
+
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxURLLength         = 2048
+
+	userAgent = "SwirlBot/1.0 (+link preview)"
+)
+
+var (
+	// ErrBlockedAddress адрес ведет во внутреннюю сеть или на нестандартный порт
+	ErrBlockedAddress = errors.New("preview: address is not allowed")
+	// ErrNotHTML по ссылке не HTML-страница
+	ErrNotHTML = errors.New("preview: not an html page")
+)
+
+// Диапазоны, которых нет среди проверок netip.Addr: общий NAT, benchmark-сети, NAT64 и т.п.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Config ограничения загрузки страниц
+type Config struct {
+	Timeout     time.Duration // На весь запрос вместе с редиректами
+	MaxBodySize int64
+	// Разрешить адреса внутренних сетей и любые порты - только для локальных тестовых серверов
+	AllowPrivateNetworks bool
+}
+
+// Preview карточка ссылки из метатегов Open Graph и Twitter
+type Preview struct {
+	URL         string
+	SiteName    string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Fetcher загружает страницы для превью. Адрес проверяется после резолвинга DNS
+// при каждом соединении, поэтому редирект или DNS rebinding во внутреннюю сеть не пройдут
+type Fetcher struct {
+	client      *http.Client
+	maxBodySize int64
+}
+
+func NewFetcher(cfg Config) *Fetcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultMaxBodySize
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = checkAddress
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // Через прокси проверка адреса потеряла бы смысл
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// via - уже выполненные запросы: первый и maxRedirects переходов
+				if len(via) > maxRedirects {
+					return errors.New("preview: too many redirects")
+				}
+				if !isWebURL(req.URL) {
+					return ErrBlockedAddress
+				}
+				return nil
+			},
+		},
+		maxBodySize: cfg.MaxBodySize,
+	}
+}
+
+// checkAddress вызывается для уже разрешенного адреса перед соединением
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port != "80" && port != "443" {
+		return ErrBlockedAddress
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || isBlockedIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// isBlockedIP адреса, куда сервер не должен ходить по ссылкам пользователей
+func isBlockedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWebURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Fetch загружает страницу и собирает превью. Если на странице нет ни заголовка,
+// ни описания, ни картинки, возвращает nil без ошибки
+func (f *Fetcher) Fetch(ctx context.Context, link string) (*Preview, error) {
+	target, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if !isWebURL(target) {
+		return nil, ErrBlockedAddress
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("preview: unexpected status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxBodySize), contentType)
+	if err != nil {
+		return nil, err
+	}
+	preview := parseHead(body, resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
+		return nil, nil
+	}
+	preview.URL = link
+	return preview, nil
+}
+
+// parseHead читает метатеги до конца <head>. Open Graph важнее Twitter, Twitter - важнее обычных тегов
+func parseHead(body io.Reader, base *url.URL) *Preview {
+	var (
+		og, twitter = map[string]string{}, map[string]string{}
+		description string
+		title       strings.Builder
+		inTitle     bool
+	)
+
+	tokenizer := html.NewTokenizer(body)
+parse:
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			break parse
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				break parse
+			case "title":
+				inTitle = title.Len() == 0
+			case "meta":
+				key, content := metaAttrs(token)
+				switch {
+				case strings.HasPrefix(key, "og:"):
+					setOnce(og, key, content)
+				case strings.HasPrefix(key, "twitter:"):
+					setOnce(twitter, key, content)
+				case key == "description" && description == "":
+					description = content
+				}
+			}
+		case html.EndTagToken:
+			switch tokenizer.Token().Data {
+			case "head":
+				break parse
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(tokenizer.Text())
+			}
+		}
+	}
+
+	preview := &Preview{
+		SiteName:    clean(og["og:site_name"], maxTitleLength),
+		Title:       clean(first(og["og:title"], twitter["twitter:title"], title.String()), maxTitleLength),
+		Description: clean(first(og["og:description"], twitter["twitter:description"], description), maxDescriptionLength),
+	}
+	image := first(og["og:image:secure_url"], og["og:image"], og["og:image:url"], twitter["twitter:image"], twitter["twitter:image:src"])
+	if image != "" {
+		if ref, err := base.Parse(strings.TrimSpace(image)); err == nil && isWebURL(ref) && len(ref.String()) <= maxURLLength {
+			preview.ImageURL = ref.String()
+		}
+	}
+	return preview
+}
+
+// metaAttrs имя метатега (property или name, в нижнем регистре) и его content
+func metaAttrs(token html.Token) (string, string) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property":
+			key = strings.ToLower(strings.TrimSpace(attr.Val))
+		case "name":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(attr.Val))
+			}
+		case "content":
+			content = attr.Val
+		}
+	}
+	return key, content
+}
+
+func setOnce(values map[string]string, key, value string) {
+	if _, ok := values[key]; !ok && strings.TrimSpace(value) != "" {
+		values[key] = value
+	}
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// clean схлопывает пробелы, убирает битый UTF-8 и обрезает текст до limit символов
+func clean(text string, limit int) string {
+	text = strings.Join(strings.Fields(strings.ToValidUTF8(text, "")), " ")
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
@@ -0,0 +1,319 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// page отдает HTML с заданным Content-Type
+func page(contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, body)
+	}
+}
+
+// fetchLocal загружает страницу с локального тестового сервера
+func fetchLocal(t *testing.T, cfg Config, handler http.Handler) (*Preview, error) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cfg.AllowPrivateNetworks = true
+	return NewFetcher(cfg).Fetch(context.Background(), server.URL+"/page")
+}
+
+func TestParseHead(t *testing.T) {
+	base, _ := url.Parse("https://example.com/news/item")
+	long := strings.Repeat("я", maxTitleLength+10)
+
+	tests := []struct {
+		name string
+		head string
+		want Preview
+	}{
+		{
+			name: "open graph first",
+			head: `<title>Title</title>
+				<meta name="twitter:title" content="Twitter">
+				<meta property="og:title" content="OG">
+				<meta name="description" content="Plain">
+				<meta name="twitter:description" content="Twitter description">
+				<meta property="og:description" content="OG description">
+				<meta property="og:site_name" content="Example">`,
+			want: Preview{SiteName: "Example", Title: "OG", Description: "OG description"},
+		},
+		{
+			name: "twitter before plain tags",
+			head: `<title>Title</title>
+				<meta name="description" content="Plain">
+				<meta name="twitter:title" content="Twitter">
+				<meta name="twitter:description" content="Twitter description">`,
+			want: Preview{Title: "Twitter", Description: "Twitter description"},
+		},
+		{
+			name: "plain tags",
+			head: `<title>  Just
+				a title </title><meta name="Description" content="Plain">`,
+			want: Preview{Title: "Just a title", Description: "Plain"},
+		},
+		{
+			name: "empty og falls back",
+			head: `<meta property="og:title" content="  "><title>Title</title>`,
+			want: Preview{Title: "Title"},
+		},
+		{
+			name: "first value wins",
+			head: `<meta property="og:title" content="First"><meta property="og:title" content="Second">
+				<title>One</title><title>Two</title>`,
+			want: Preview{Title: "First"},
+		},
+		{
+			name: "relative image",
+			head: `<meta property="og:image" content="/img/cover.png">`,
+			want: Preview{ImageURL: "https://example.com/img/cover.png"},
+		},
+		{
+			name: "secure image first",
+			head: `<meta name="twitter:image" content="https://cdn.example.com/t.png">
+				<meta property="og:image" content="http://cdn.example.com/og.png">
+				<meta property="og:image:secure_url" content="https://cdn.example.com/og.png">`,
+			want: Preview{ImageURL: "https://cdn.example.com/og.png"},
+		},
+		{
+			name: "twitter image",
+			head: `<meta name="twitter:image:src" content="https://cdn.example.com/t.png">`,
+			want: Preview{ImageURL: "https://cdn.example.com/t.png"},
+		},
+		{
+			name: "javascript image",
+			head: `<meta property="og:image" content="javascript:alert(1)">`,
+		},
+		{
+			name: "data image",
+			head: `<meta property="og:image" content="data:image/png;base64,iVBORw0KGgo=">`,
+		},
+		{
+			name: "long title",
+			head: `<title>` + long + `</title>`,
+			want: Preview{Title: strings.Repeat("я", maxTitleLength-1) + "…"},
+		},
+		{
+			name: "broken utf-8",
+			head: "<title>ok\xff\xfe</title>",
+			want: Preview{Title: "ok"},
+		},
+		{
+			name: "tags in body ignored",
+			head: `</head><body><meta property="og:title" content="Body"><title>Body</title>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHead(strings.NewReader("<html><head>"+tt.head+"</head><body></body></html>"), base)
+			if *got != tt.want {
+				t.Errorf("parseHead = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	preview, err := fetchLocal(t, Config{}, page("text/html; charset=utf-8",
+		`<html><head><meta property="og:title" content="Привет"><meta property="og:image" content="/a.png"></head></html>`))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "Привет" || !strings.HasSuffix(preview.ImageURL, "/a.png") || !strings.HasSuffix(preview.URL, "/page") {
+		t.Errorf("preview = %+v", preview)
+	}
+}
+
+func TestFetchCharset(t *testing.T) {
+	// "Привет" в windows-1251
+	const cp1251 = "\xcf\xf0\xe8\xe2\xe5\xf2"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "header", contentType: "text/html; charset=windows-1251",
+			body: "<html><head><title>" + cp1251 + "</title></head></html>"},
+		{name: "meta charset", contentType: "text/html",
+			body: `<html><head><meta charset="windows-1251"><title>` + cp1251 + "</title></head></html>"},
+		{name: "meta http-equiv", contentType: "text/html",
+			body: `<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><title>` + cp1251 + "</title></head></html>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, err := fetchLocal(t, Config{}, page(tt.contentType, tt.body))
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if preview == nil || preview.Title != "Привет" {
+				t.Errorf("preview = %+v, want title Привет", preview)
+			}
+		})
+	}
+}
+
+func TestFetchBodyLimit(t *testing.T) {
+	// Метатеги за первым мегабайтом не читаются
+	padding := "<!--" + strings.Repeat("x", defaultMaxBodySize) + "-->"
+	body := `<html><head><title>Early</title>` + padding + `<meta property="og:title" content="Late"></head></html>`
+	preview, err := fetchLocal(t, Config{}, page("text/html; charset=utf-8", body))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview == nil || preview.Title != "Early" {
+		t.Errorf("preview = %+v, want title Early", preview)
+	}
+
+	// Страница, у которой метатеги целиком за пределом, превью не дает
+	body = `<html><head>` + padding + `<meta property="og:title" content="Late"></head></html>`
+	if preview, err := fetchLocal(t, Config{}, page("text/html; charset=utf-8", body)); err != nil || preview != nil {
+		t.Errorf("Fetch = %+v, %v; want nil, nil", preview, err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	started := time.Now()
+	if _, err := fetchLocal(t, Config{Timeout: 100 * time.Millisecond}, slow); err == nil {
+		t.Fatal("Fetch succeeded, want timeout")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %s, want about 100ms", elapsed)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	// /hop/N уводит на /hop/N-1, /hop/0 - страница
+	hops := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &n); err != nil || n == 0 {
+			page("text/html", `<html><head><title>Target</title></head></html>`)(w, r)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+	})
+	server := httptest.NewServer(hops)
+	defer server.Close()
+	fetcher := NewFetcher(Config{AllowPrivateNetworks: true})
+
+	tests := []struct {
+		hops    int
+		wantErr bool
+	}{
+		{hops: 0},
+		{hops: maxRedirects},
+		{hops: maxRedirects + 1, wantErr: true},
+		{hops: maxRedirects + 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d hops", tt.hops), func(t *testing.T) {
+			preview, err := fetcher.Fetch(context.Background(), fmt.Sprintf("%s/hop/%d", server.URL, tt.hops))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch = %+v, %v; wantErr %v", preview, err, tt.wantErr)
+			}
+			if !tt.wantErr && (preview == nil || preview.Title != "Target") {
+				t.Errorf("preview = %+v, want title Target", preview)
+			}
+		})
+	}
+
+	// Редирект на другую схему не выполняется
+	ftp := httptest.NewServer(http.RedirectHandler("ftp://example.com/file", http.StatusFound))
+	defer ftp.Close()
+	if _, err := fetcher.Fetch(context.Background(), ftp.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("redirect to ftp = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchRejects(t *testing.T) {
+	server := httptest.NewServer(page("text/html", `<html><head><title>Local</title></head></html>`))
+	defer server.Close()
+	image := httptest.NewServer(page("image/png", "\x89PNG"))
+	defer image.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	strict := NewFetcher(Config{})
+	lenient := NewFetcher(Config{AllowPrivateNetworks: true})
+	tests := []struct {
+		name    string
+		fetcher *Fetcher
+		link    string
+		want    error
+	}{
+		// Тестовый сервер слушает loopback на случайном порту: закрыто и то, и другое
+		{name: "loopback", fetcher: strict, link: server.URL, want: ErrBlockedAddress},
+		{name: "loopback by name", fetcher: strict, link: strings.Replace(server.URL, "127.0.0.1", "localhost", 1), want: ErrBlockedAddress},
+		{name: "ftp", fetcher: lenient, link: "ftp://example.com/file", want: ErrBlockedAddress},
+		{name: "javascript", fetcher: lenient, link: "javascript:alert(1)", want: ErrBlockedAddress},
+		{name: "not html", fetcher: lenient, link: image.URL, want: ErrNotHTML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.fetcher.Fetch(context.Background(), tt.link); !errors.Is(err, tt.want) {
+				t.Errorf("Fetch = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := lenient.Fetch(context.Background(), missing.URL); err == nil {
+		t.Error("Fetch of 404 succeeded")
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"93.184.216.34:8080", false},
+		{"93.184.216.34:22", false},
+		{"127.0.0.1:80", false},
+		{"127.1.2.3:443", false},
+		{"[::1]:443", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"10.0.0.1:80", false},
+		{"172.16.5.4:80", false},
+		{"192.168.1.1:443", false},
+		{"[fd00::1]:443", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::]:80", false},
+		{"100.64.0.1:80", false},
+		{"224.0.0.1:80", false},
+		{"[64:ff9b::7f00:1]:80", false},
+		{"example.com:80", false},
+	}
+	for _, tt := range tests {
+		err := checkAddress("tcp", tt.address, nil)
+		if tt.allowed && err != nil {
+			t.Errorf("checkAddress(%s) = %v, want allowed", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("checkAddress(%s) = %v, want ErrBlockedAddress", tt.address, err)
+		}
+	}
+}
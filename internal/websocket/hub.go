@@ -20,6 +20,13 @@ type Hub struct {
 	Broadcast  chan Message
 	register   chan *Client
 	unregister chan *Client
+	kick       chan kickRequest
+}
+
+// kickRequest запрос на отключение пользователя от чата; пустой chatID - от всех чатов
+type kickRequest struct {
+	userID string
+	chatID string
 }
 
 type Client struct {
@@ -34,6 +41,32 @@ type Message struct {
 	Type    string      `json:"type"`
 	ChatID  string      `json:"chat_id"`
 	Payload interface{} `json:"payload"`
+
+	// Если задан, сообщение получают только эти пользователи чата
+	Recipients []string `json:"-"`
+
+	// Сообщение получают все соединения Recipients, в каком бы чате они ни были открыты
+	AllChats bool `json:"-"`
+}
+
+// deliverTo проверяет, адресовано ли сообщение клиенту
+func (m *Message) deliverTo(client *Client) bool {
+	if m.AllChats {
+		if len(m.Recipients) == 0 {
+			return false
+		}
+	} else if client.chatID != m.ChatID {
+		return false
+	}
+	if len(m.Recipients) == 0 {
+		return true
+	}
+	for _, userID := range m.Recipients {
+		if userID == client.userID {
+			return true
+		}
+	}
+	return false
 }
 
 func NewHub() *Hub {
@@ -42,6 +75,7 @@ func NewHub() *Hub {
 		Broadcast:  make(chan Message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		kick:       make(chan kickRequest),
 	}
 }
 
@@ -59,10 +93,19 @@ func (h *Hub) Run() {
 				log.Printf("Client disconnected: %s", client.userID)
 			}
 
+		case req := <-h.kick:
+			for client := range h.clients {
+				if client.userID == req.userID && (req.chatID == "" || client.chatID == req.chatID) {
+					delete(h.clients, client)
+					close(client.send)
+					log.Printf("Client removed from chat %s: %s", client.chatID, req.userID)
+				}
+			}
+
 		case message := <-h.Broadcast:
 			for client := range h.clients {
-				// Отправляем сообщение только участникам конкретного чата
-				if client.chatID == message.ChatID {
+				// Отправляем сообщение только участникам конкретного чата (или выбранным из них)
+				if message.deliverTo(client) {
 					select {
 					case client.send <- h.encodeMessage(message):
 					default:
@@ -75,6 +118,16 @@ func (h *Hub) Run() {
 	}
 }
 
+// Disconnect отключает соединения пользователя от чата (например, после удаления из участников)
+func (h *Hub) Disconnect(userID, chatID string) {
+	h.kick <- kickRequest{userID: userID, chatID: chatID}
+}
+
+// DisconnectUser отключает все соединения пользователя (например, после блокировки)
+func (h *Hub) DisconnectUser(userID string) {
+	h.kick <- kickRequest{userID: userID}
+}
+
 func (h *Hub) encodeMessage(message Message) []byte {
 	data, _ := json.Marshal(message)
 	return data
@@ -8,7 +8,10 @@ import (
 	"swirl-backend/internal/config"
 	"swirl-backend/internal/database"
 	"swirl-backend/internal/handlers"
+	"swirl-backend/internal/mailer"
 	"swirl-backend/internal/middleware"
+	"swirl-backend/internal/models"
+	"swirl-backend/internal/oidc"
 	"swirl-backend/internal/websocket"
 
 	"github.com/gin-contrib/cors"
@@ -36,6 +39,38 @@ func main() {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Назначаем роли из конфигурации
+	if err := database.BootstrapRoles(db, cfg.AdminUsernames, cfg.ModeratorUsernames); err != nil {
+		log.Fatal("Failed to bootstrap roles:", err)
+	}
+
+	// Инициализируем отправку почты
+	mail, err := mailer.New(mailer.Config{
+		Driver:   cfg.MailerDriver,
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+
+	// Провайдеры входа через OpenID Connect
+	var oidcConfigs []oidc.ProviderConfig
+	for _, p := range cfg.OIDCProviders {
+		oidcConfigs = append(oidcConfigs, oidc.ProviderConfig{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		})
+	}
+	oidcProviders := oidc.NewRegistry(oidcConfigs)
+
 	// Инициализируем WebSocket hub
 	hub := websocket.NewHub()
 	go hub.Run()
@@ -55,6 +90,31 @@ func main() {
 		}
 	}()
 
+	// Инициализируем хендлеры
+	authHandler := handlers.NewAuthHandler(db, cfg, mail)
+
+	// Периодически удаляем неактивные гостевые аккаунты (раз в час)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := authHandler.CleanupIdleGuests(); err != nil {
+				log.Printf("Failed to cleanup idle guests: %v", err)
+			}
+		}
+	}()
+
+	// Удаляем устаревшие ключи идемпотентности (раз в час)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := middleware.CleanupIdempotencyKeys(db, cfg.IdempotencyKeyTTL); err != nil {
+				log.Printf("Failed to cleanup idempotency keys: %v", err)
+			}
+		}
+	}()
+
 	// Создаем Gin роутер
 	r := gin.Default()
 
@@ -72,60 +132,177 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	// Инициализируем хендлеры
-	authHandler := handlers.NewAuthHandler(db)
-	chatHandler := handlers.NewChatHandler(db, hub)
-	messageHandler := handlers.NewMessageHandler(db, hub)
-	uploadHandler := handlers.NewUploadHandler("./uploads")
+	oidcHandler := handlers.NewOIDCHandler(db, oidcProviders, authHandler)
+	chatHandler := handlers.NewChatHandler(db, hub, cfg.JWTSecret)
+	messageHandler := handlers.NewMessageHandler(db, hub, cfg)
+	uploadHandler := handlers.NewUploadHandler(db, cfg.UploadPath)
 	chatrouletteHandler := handlers.NewChatrouletteHandler(db)
+	adminHandler := handlers.NewAdminHandler(db, hub)
+	stickerHandler := handlers.NewStickerHandler(db)
+
+	// Стираем исчезнувшие сообщения (каждые 5 секунд)
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := messageHandler.ReapExpiredMessages(); err != nil {
+				log.Printf("Failed to reap expired messages: %v", err)
+			}
+		}
+	}()
+
+	// Отправляем отложенные сообщения (каждые 15 секунд)
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := messageHandler.DeliverScheduledMessages(); err != nil {
+				log.Printf("Failed to deliver scheduled messages: %v", err)
+			}
+		}
+	}()
+
+	// Удаляем устаревшие превью ссылок (раз в час)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := messageHandler.CleanupLinkPreviews(); err != nil {
+				log.Printf("Failed to cleanup link previews: %v", err)
+			}
+		}
+	}()
 
 	// Публичные роуты
 	api := r.Group("/api/v1")
 	{
 		api.POST("/register", authHandler.Register)
 		api.POST("/login", authHandler.Login)
+		api.POST("/auth/guest", authHandler.CreateGuest)
+		api.POST("/password/forgot", authHandler.ForgotPassword)
+		api.POST("/password/reset", authHandler.ResetPassword)
+		api.POST("/email/verify", authHandler.VerifyEmail)
+
+		// Вход через внешних провайдеров (OIDC)
+		api.GET("/auth/oidc/providers", oidcHandler.GetProviders)
+		api.GET("/auth/oidc/:provider/start", oidcHandler.StartLogin)
+		api.GET("/auth/oidc/:provider/callback", oidcHandler.Callback)
 	}
 
 	// Защищенные роуты
 	protected := api.Group("/")
-	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret, db))
+	protected.Use(middleware.GuestAccess(db))
+	protected.Use(middleware.Idempotency(db))
 	{
 		// Пользователи
 		protected.GET("/profile", authHandler.GetProfile)
 		protected.PUT("/profile", authHandler.UpdateProfile)
+		protected.PUT("/profile/password", authHandler.ChangePassword)
+		protected.PUT("/profile/email", authHandler.ChangeEmail)
+		protected.GET("/profile/identities", oidcHandler.GetIdentities)
+		protected.POST("/profile/identities/:provider", oidcHandler.StartLink)
+		protected.DELETE("/profile/identities/:provider", oidcHandler.UnlinkIdentity)
 		protected.POST("/profile/online", authHandler.UpdateOnlineStatus)
+		protected.POST("/profile/upgrade", authHandler.UpgradeGuest)
 		protected.GET("/users/:id/profile", authHandler.GetPublicProfile)
+		protected.POST("/users/:id/dm", chatHandler.GetOrCreateDirectChat)
+		protected.POST("/users/:id/block", authHandler.BlockUser)
+		protected.DELETE("/users/:id/block", authHandler.UnblockUser)
+		protected.GET("/profile/blocks", authHandler.GetBlockedUsers)
 
 		// Чаты
 		protected.GET("/chats", chatHandler.GetChats)
 		protected.POST("/chats", chatHandler.CreateChat)
 		protected.GET("/chats/:id", chatHandler.GetChat)
+		protected.PUT("/chats/:id", chatHandler.UpdateChat)
+		protected.PUT("/chats/:id/message-ttl", chatHandler.SetMessageTTL)
+		protected.PUT("/chats/:id/mute", chatHandler.MuteChat)
 		protected.DELETE("/chats/:id", chatHandler.DeleteChat)
 
+		// Участники групповых чатов
+		protected.POST("/chats/:id/members", chatHandler.AddMembers)
+		protected.DELETE("/chats/:id/members/:user_id", chatHandler.RemoveMember)
+		protected.PUT("/chats/:id/members/:user_id/role", chatHandler.UpdateMemberRole)
+		protected.POST("/chats/:id/leave", chatHandler.LeaveChat)
+
+		// Ссылки-приглашения
+		protected.POST("/chats/:id/invites", chatHandler.CreateInvite)
+		protected.GET("/chats/:id/invites", chatHandler.GetInvites)
+		protected.DELETE("/chats/:id/invites/:invite_id", chatHandler.RevokeInvite)
+		protected.GET("/chats/:id/join-requests", chatHandler.GetJoinRequests)
+		protected.POST("/chats/:id/join-requests/:request_id/approve", chatHandler.ApproveJoinRequest)
+		protected.POST("/chats/:id/join-requests/:request_id/reject", chatHandler.RejectJoinRequest)
+		protected.GET("/invites/:code", chatHandler.PreviewInvite)
+		protected.POST("/invites/:code/join", chatHandler.JoinByInvite)
+
 		// Сообщения
 		protected.GET("/chats/:id/messages", messageHandler.GetMessages)
 		protected.POST("/chats/:id/messages", messageHandler.SendMessage)
+		protected.GET("/chats/:id/messages/search", messageHandler.SearchChatMessages)
+		protected.GET("/chats/:id/messages/scheduled", messageHandler.GetScheduledMessages)
+		protected.PUT("/scheduled-messages/:id", messageHandler.UpdateScheduledMessage)
+		protected.DELETE("/scheduled-messages/:id", messageHandler.CancelScheduledMessage)
+		protected.GET("/search/messages", messageHandler.SearchMessages)
 		protected.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		protected.PUT("/chats/:id/read", messageHandler.MarkChatAsRead)
+		protected.POST("/chats/:id/mentions/next", messageHandler.ReadNextMention)
 		protected.PUT("/messages/:id/read", messageHandler.MarkMessageAsRead)
 		protected.PUT("/messages/:id/edit", messageHandler.EditMessage)
 		protected.GET("/messages/:id/status", messageHandler.GetMessageStatus)
+		protected.GET("/messages/:id/history", messageHandler.GetMessageHistory)
+		protected.GET("/messages/:id/thread", messageHandler.GetThread)
+		protected.POST("/messages/:id/forward", messageHandler.ForwardMessage)
+		protected.POST("/messages/forward", messageHandler.ForwardMessages)
 		
-		// Лайки сообщений
+		// Реакции на сообщения (like - старый вариант реакции ❤️)
+		protected.GET("/messages/:id/reactions", messageHandler.GetReactions)
+		protected.POST("/messages/:id/reactions", messageHandler.AddReaction)
+		protected.DELETE("/messages/:id/reactions/:emoji", messageHandler.RemoveReaction)
 		protected.POST("/messages/:id/like", messageHandler.LikeMessage)
 		protected.DELETE("/messages/:id/like", messageHandler.UnlikeMessage)
-		protected.GET("/messages/:id/likes", messageHandler.GetMessageLikes)
+		protected.GET("/messages/:id/likes", messageHandler.GetReactions)
+
+		// Опросы
+		protected.POST("/messages/:id/poll/vote", messageHandler.VotePoll)
+		protected.GET("/messages/:id/poll/voters", messageHandler.GetPollVoters)
 
 		// Загрузка файлов
 		protected.POST("/upload", uploadHandler.UploadFile)
 		protected.DELETE("/uploads/:file_name", uploadHandler.DeleteFile)
 
+		// Стикеры
+		protected.GET("/stickers", stickerHandler.GetStickers)
+
 		// Swirl (случайные встречи)
 		protected.GET("/swirl/find", chatrouletteHandler.FindRandomUser)
 		protected.POST("/swirl/:id/save", chatrouletteHandler.SaveChat)
 		protected.POST("/swirl/:id/skip", chatrouletteHandler.SkipUser)
 		protected.POST("/swirl/activity", chatrouletteHandler.UpdateSearchActivity)
 		protected.GET("/swirl/status", chatrouletteHandler.GetQueueStatus)
-		protected.DELETE("/swirl/clear", chatrouletteHandler.ClearQueue)
+	}
+
+	// Административные роуты
+	admin := protected.Group("/admin")
+	admin.Use(middleware.RequireRole(models.RoleAdmin, models.RoleModerator))
+	{
+		// Очередь чатрулетки
+		admin.DELETE("/swirl/queue", middleware.RequirePermission(models.PermissionQueueManage), chatrouletteHandler.ClearQueue)
+
+		// Пользователи
+		admin.GET("/users", middleware.RequirePermission(models.PermissionUsersView), adminHandler.GetUsers)
+		admin.GET("/users/:id", middleware.RequirePermission(models.PermissionUsersView), adminHandler.GetUser)
+		admin.PUT("/users/:id/role", middleware.RequirePermission(models.PermissionUsersRoles), adminHandler.UpdateUserRole)
+		admin.POST("/users/:id/block", middleware.RequirePermission(models.PermissionUsersBlock), adminHandler.BlockUser)
+		admin.DELETE("/users/:id/block", middleware.RequirePermission(models.PermissionUsersBlock), adminHandler.UnblockUser)
+
+		// Модерация
+		admin.DELETE("/messages/:id", middleware.RequirePermission(models.PermissionMessagesModerate), adminHandler.DeleteMessage)
+		admin.DELETE("/chats/:id", middleware.RequirePermission(models.PermissionChatsModerate), adminHandler.DeleteChat)
+
+		// Каталог стикеров
+		admin.POST("/stickers", middleware.RequirePermission(models.PermissionStickersManage), stickerHandler.CreateSticker)
+		admin.DELETE("/stickers/:id", middleware.RequirePermission(models.PermissionStickersManage), stickerHandler.DeleteSticker)
 	}
 
 	// WebSocket для real-time общения (без middleware авторизации)